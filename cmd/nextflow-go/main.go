@@ -9,10 +9,43 @@ import (
 
 func main() {
         if len(os.Args) == 1 {
-                fmt.Println("usage: nextflow-go [all nextflow arguments]")
+                fmt.Println("usage: nextflow-go [all nextflow arguments] [-detach] [-dry-run [-o yaml|json] [-show-secrets]]")
+                fmt.Println("       nextflow-go attach <run> [-n namespace] [-tail lines]")
+                fmt.Println("       nextflow-go wait <run> [-n namespace] [-timeout duration]")
+                fmt.Println("       nextflow-go status <run> [-n namespace] [-o text|json]")
+                fmt.Println("       nextflow-go list [-n namespace | -A] [-status s] [-user u] [-l selector] [-o json]")
+                fmt.Println("       nextflow-go kill <run> [-n namespace] [-grace duration]")
+                fmt.Println("       nextflow-go fetch <run> [-n namespace] [-d dir]")
+                fmt.Println("       nextflow-go pull <run> [path] [-v pvc:dir] [-d dir] [-include glob] [-exclude glob]")
+                fmt.Println("       nextflow-go resume <run> [-n namespace] [options to override]")
                 os.Exit(0)
         }
-	fmt.Println("Running Nextflow K8s Job...")
-	kube.Execute(false)
+        switch os.Args[1] {
+        case "attach":
+                kube.Attach()
+                return
+        case "wait":
+                kube.Wait()
+                return
+        case "status":
+                kube.Status()
+                return
+        case "list":
+                kube.List()
+                return
+        case "kill":
+                kube.Kill()
+                return
+        case "fetch":
+                kube.Fetch()
+                return
+        case "pull":
+                kube.Pull()
+                return
+        case "resume":
+                kube.Resume()
+                return
+        }
+	kube.Execute()
 }
 
@@ -0,0 +1,73 @@
+package args
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+type AttachArgs struct {
+	RunName     string
+	Namespace   string
+	ConfigName  string
+	Tail        int64
+	OnInterrupt string
+}
+
+// ParseAttachArgs parses `nextflow-go attach <run> [options]`.
+func ParseAttachArgs() AttachArgs {
+	args := os.Args[2:]
+	attachArgs := AttachArgs{
+		ConfigName:  "nextflow.config",
+		Tail:        100,
+		OnInterrupt: "detach",
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-n", "-namespace":
+			attachArgs.Namespace = value(args, &i)
+		case "-C":
+			attachArgs.ConfigName = value(args, &i)
+		case "-tail":
+			tail, err := strconv.ParseInt(value(args, &i), 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("invalid -tail value: %v", err))
+			}
+			attachArgs.Tail = tail
+		case "-on-interrupt":
+			policy, err := interruptPolicy(value(args, &i))
+			if err != nil {
+				panic(err)
+			}
+			attachArgs.OnInterrupt = policy
+		default:
+			if attachArgs.RunName != "" {
+				panic(fmt.Sprintf("unexpected argument '%s'", args[i]))
+			}
+			attachArgs.RunName = args[i]
+		}
+	}
+
+	if attachArgs.RunName == "" {
+		fmt.Println("usage: nextflow-go attach <run> [-n namespace] [-tail lines] [-on-interrupt detach|cancel]")
+		os.Exit(1)
+	}
+	return attachArgs
+}
+
+// value returns the argument following the option at *i and advances *i.
+func value(args []string, i *int) string {
+	if *i+1 >= len(args) {
+		panic(fmt.Sprintf("option %s requires a value", args[*i]))
+	}
+	*i++
+	return args[*i]
+}
+
+func interruptPolicy(policy string) (string, error) {
+	if policy != "detach" && policy != "cancel" {
+		return "", fmt.Errorf("invalid -on-interrupt value '%s', expected detach or cancel", policy)
+	}
+	return policy, nil
+}
@@ -0,0 +1,44 @@
+package args
+
+import (
+	"fmt"
+	"os"
+)
+
+type FetchArgs struct {
+	RunName    string
+	Namespace  string
+	ConfigName string
+	Dir        string
+}
+
+// ParseFetchArgs parses `nextflow-go fetch <run> [options]`.
+func ParseFetchArgs() FetchArgs {
+	args := os.Args[2:]
+	fetchArgs := FetchArgs{ConfigName: "nextflow.config"}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-n", "-namespace":
+			fetchArgs.Namespace = value(args, &i)
+		case "-C":
+			fetchArgs.ConfigName = value(args, &i)
+		case "-d", "-dir":
+			fetchArgs.Dir = value(args, &i)
+		default:
+			if fetchArgs.RunName != "" {
+				panic(fmt.Sprintf("unexpected argument '%s'", args[i]))
+			}
+			fetchArgs.RunName = args[i]
+		}
+	}
+
+	if fetchArgs.RunName == "" {
+		fmt.Println("usage: nextflow-go fetch <run> [-n namespace] [-d dir]")
+		os.Exit(1)
+	}
+	if fetchArgs.Dir == "" {
+		fetchArgs.Dir = fetchArgs.RunName
+	}
+	return fetchArgs
+}
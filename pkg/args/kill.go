@@ -0,0 +1,49 @@
+package args
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+type KillArgs struct {
+	RunName    string
+	Namespace  string
+	ConfigName string
+	Grace      time.Duration
+}
+
+// ParseKillArgs parses `nextflow-go kill <run> [options]`.
+func ParseKillArgs() KillArgs {
+	args := os.Args[2:]
+	killArgs := KillArgs{
+		ConfigName: "nextflow.config",
+		Grace:      2 * time.Minute,
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-n", "-namespace":
+			killArgs.Namespace = value(args, &i)
+		case "-C":
+			killArgs.ConfigName = value(args, &i)
+		case "-grace":
+			grace, err := time.ParseDuration(value(args, &i))
+			if err != nil {
+				panic(fmt.Sprintf("invalid -grace value: %v", err))
+			}
+			killArgs.Grace = grace
+		default:
+			if killArgs.RunName != "" {
+				panic(fmt.Sprintf("unexpected argument '%s'", args[i]))
+			}
+			killArgs.RunName = args[i]
+		}
+	}
+
+	if killArgs.RunName == "" {
+		fmt.Println("usage: nextflow-go kill <run> [-n namespace] [-grace duration]")
+		os.Exit(1)
+	}
+	return killArgs
+}
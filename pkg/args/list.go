@@ -0,0 +1,47 @@
+package args
+
+import (
+	"fmt"
+	"os"
+)
+
+type ListArgs struct {
+	Namespace     string
+	AllNamespaces bool
+	ConfigName    string
+	Status        string
+	User          string
+	Selector      string
+	Output        string
+}
+
+// ParseListArgs parses `nextflow-go list [options]`.
+func ParseListArgs() ListArgs {
+	args := os.Args[2:]
+	listArgs := ListArgs{ConfigName: "nextflow.config"}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-n", "-namespace":
+			listArgs.Namespace = value(args, &i)
+		case "-A", "-all-namespaces":
+			listArgs.AllNamespaces = true
+		case "-C":
+			listArgs.ConfigName = value(args, &i)
+		case "-status":
+			listArgs.Status = value(args, &i)
+		case "-user":
+			listArgs.User = value(args, &i)
+		case "-l", "-selector":
+			listArgs.Selector = value(args, &i)
+		case "-o", "-output":
+			listArgs.Output = value(args, &i)
+			if listArgs.Output != "json" && listArgs.Output != "table" {
+				panic(fmt.Sprintf("invalid output format '%s', expected table or json", listArgs.Output))
+			}
+		default:
+			panic(fmt.Sprintf("unexpected argument '%s'", args[i]))
+		}
+	}
+	return listArgs
+}
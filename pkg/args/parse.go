@@ -1,52 +1,185 @@
 package args
 
 import (
+	"fmt"
+	"math"
 	"os"
-        "nextflow-go/pkg/utils"
+	"strconv"
         "path/filepath"
+        "regexp"
 	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// DefaultHeadImage is the Nextflow image used for the head pod.
+const DefaultHeadImage = "cerit.io/nextflow/nextflow:25.04.4"
+
 type Args struct {
-	JobName     string
+	// JobName is the run name, given with -name or generated from
+	// NameTemplate at submit time. It names the head job.
+	JobName      string
+	NameTemplate string
+	// Help is set when nextflow run -help is requested, which is run
+	// without a run name.
+	Help        bool
 	Nextflow    []string
 	Volumes     []string
 	HeadImage   string
 	HeadCPUs    string
 	HeadMemory  string
+        // The explicit requests and limits of the head pod override the
+        // ones derived from HeadCPUs and HeadMemory. With HeadNoLimits the
+        // head pod gets requests only.
+        HeadCPURequest       string
+        HeadCPULimit         string
+        HeadMemoryRequest    string
+        HeadMemoryLimit      string
+        HeadEphemeralStorage string
+        HeadNoLimits         bool
+        // Scheduling of the head pod.
+        HeadNodeSelector  []string
+        HeadTolerations   []string
+        HeadAffinityFile  string
+        HeadPriorityClass string
+        HeadRuntimeClass  string
+        // HeadPodTemplate is a file patched onto the head job.
+        HeadPodTemplate      string
+        HeadPodTemplateForce bool
+        // DryRun prints the objects of the run as YAML or JSON (Output)
+        // instead of creating them, with the secret values redacted unless
+        // ShowSecrets is set.
+        DryRun      bool
+        Output      string
+        ShowSecrets bool
         ConfigName  string
         ParamsFile  string
         CustomFile  string
+        // Ttl is how many seconds the finished head job is kept, or
+        // TtlNever to keep it until it is deleted. MaxRuntime limits how
+        // many seconds the run may take, 0 means no limit.
         Ttl         int32
+        MaxRuntime  int64
+        // KeepRecord keeps the config secret and a summary of the run after
+        // the head job is removed.
+        KeepRecord  bool
+        // Labels and Annotations are key=value pairs added to the head job
+        // and its pod.
+        Labels      []string
+        Annotations []string
+        // Detach returns right after the run is submitted instead of
+        // following its output.
+        Detach      bool
+        OnInterrupt string
+        FetchDir    string
+        NoStageInputs bool
+        CreateMissingPVCs bool
+        SkipPreflight bool
+        // HeadRetries is how often the head pod is relaunched with -resume
+        // after an infrastructure failure, HeadMemoryGrowth the factor its
+        // memory grows by when it was killed for running out of memory.
+        HeadRetries      int
+        HeadMemoryGrowth float64
+        // PipelineIndex is the position of the pipeline (a repository
+        // or local path) within Nextflow, or -1 when it was not found.
+        PipelineIndex int
+}
+
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// TtlNever is the Ttl of runs whose head job is never removed.
+const TtlNever = -1
+
+// noValueOptions are nextflow run options that are never followed by a value,
+// so the argument after them can be the pipeline.
+var noValueOptions = map[string]bool{
+        "-latest": true, "-stub": true, "-stub-run": true, "-offline": true,
+        "-preview": true, "-disable-jobs-cancellation": true, "-without-docker": true,
+        "-without-podman": true, "-without-conda": true, "-without-spack": true,
+        "-without-wave": true, "-q": true, "-quiet": true,
+        "-no-stage-inputs": true, "-create-missing-pvcs": true, "-skip-preflight": true,
+        "-head-no-limits": true, "-head-pod-template-force": true,
+        "-dry-run": true, "-show-secrets": true, "-keep-record": true,
+        "-detach": true, "-bg": true,
 }
 
-func ParseArgs() Args {
+// SetRunName sets the run name and passes it to nextflow run with -name.
+func (a *Args) SetRunName(name string) {
+        a.JobName = name
+        if a.Help {
+                return
+        }
+        if a.optionIndex("-name") >= 0 {
+                a.SetOption("-name", name)
+                return
+        }
+        a.Nextflow = append([]string{"-name", name}, a.Nextflow...)
+        if a.PipelineIndex >= 0 {
+                a.PipelineIndex += 2
+        }
+}
+
+// Option returns the value of a nextflow run option, or "" when it is not
+// given.
+func (a Args) Option(name string) string {
+        idx := a.optionIndex(name)
+        if idx < 0 || idx+1 >= len(a.Nextflow) || idx+1 == a.PipelineIndex || strings.HasPrefix(a.Nextflow[idx+1], "-") {
+                return ""
+        }
+        return a.Nextflow[idx+1]
+}
+
+// IsSessionID reports whether s is a Nextflow session id.
+func IsSessionID(s string) bool {
+        return sessionIDPattern.MatchString(s)
+}
+
+// IsResumeValue reports whether arg is taken by a preceding -resume as its
+// value, which Nextflow only does for a session id or last.
+func IsResumeValue(arg string) bool {
+        return arg == "last" || IsSessionID(arg)
+}
+
+// Pipeline returns the pipeline argument of the run, if any.
+func (a Args) Pipeline() string {
+        if a.PipelineIndex < 0 {
+                return ""
+        }
+        return a.Nextflow[a.PipelineIndex]
+}
+
+// ParseArgs parses the arguments of a run. Invalid values of launcher options
+// are returned as an error.
+func ParseArgs() (Args, error) {
 	args := os.Args[1:]
-	jobName := utils.GenerateRandomName()
-	hasName := false
-        ttl := int32(3600)
+	a := Args{
+		Nextflow:      []string{},
+		Volumes:       []string{},
+		HeadImage:     DefaultHeadImage,
+		HeadCPUs:      "1",
+		HeadMemory:    "8Gi",
+		ConfigName:    "nextflow.config",
+		Ttl:           3600,
+		OnInterrupt:   "detach",
+		Output:        "yaml",
+		HeadMemoryGrowth: 1,
+		PipelineIndex: -1,
+	}
 
 	for i, arg := range args {
                 if arg == "-help" || arg == "-h" {
-                        hasName = true // hack
-                        ttl = 10
+                        a.Help = true
+                        a.Ttl = 10
                         break
                 }
 		if arg == "-name" && i+1 < len(args) {
-			jobName = args[i+1]
-			hasName = true
+			a.JobName = args[i+1]
 			break
 		}
 	}
 
-	nextflowArgs := []string{}
-	volumesArgs := []string{}
-        paramsFile := ""
-        customFile := ""
-        configName := "nextflow.config"
-        headCPUs := "1"
-        headMemory := "8Gi"
-        headImage := "cerit.io/nextflow/nextflow:25.04.4"
 	skipNext := false
 	for i, arg := range args {
 		if skipNext {
@@ -54,57 +187,194 @@ func ParseArgs() Args {
 			continue
 		}
 		if strings.HasPrefix(arg, "-") {
-			if !hasName {
-				nextflowArgs = append(nextflowArgs, "-name", jobName)
-				hasName = true
+			consumed, ok, err := a.launcherOption(args, i)
+			if err != nil {
+				return a, err
 			}
-			switch arg {
-			case "-v":
-				volumesArgs = append(volumesArgs, args[i+1])
-				skipNext = true
-			case "-head-image", "-pod-image":
-				headImage = args[i+1]
-				skipNext = true
-			case "-head-cpus":
-				headCPUs = args[i+1]
-				skipNext = true
-			case "-head-memory":
-				headMemory = args[i+1]
-				skipNext = true
-			case "-name", "-head-prescript":
-				skipNext = true
-                        case "-C":
-                                configName = args[i+1]
-                                skipNext = true
-                        case "-c", "-config":
-                                customFile = args[i+1]
-                                skipNext = true
-                                filename := filepath.Base(customFile)
-                                nextflowArgs = append(nextflowArgs, "-c", "/etc/nextflow/"+filename)
-                        case "-params-file":
-                                paramsFile = args[i+1]
-                                skipNext = true
-                                filename := filepath.Base(paramsFile)
-                                nextflowArgs = append(nextflowArgs, "-params-file", "/etc/nextflow/"+filename)
-			default:
-				nextflowArgs = append(nextflowArgs, arg)
+			if ok {
+				skipNext = consumed
+			} else {
+				a.Nextflow = append(a.Nextflow, arg)
 			}
 		} else if arg != "run" && arg != "kuberun" {
-			nextflowArgs = append(nextflowArgs, arg)
+                        if a.PipelineIndex < 0 && (i == 0 || !strings.HasPrefix(args[i-1], "-") || noValueOptions[args[i-1]] ||
+                                (args[i-1] == "-resume" && !IsResumeValue(arg))) {
+                                a.PipelineIndex = len(a.Nextflow)
+                        }
+			a.Nextflow = append(a.Nextflow, arg)
 		}
 	}
 
-	return Args{
-		JobName:    jobName,
-		Nextflow:   nextflowArgs,
-		Volumes:    volumesArgs,
-		HeadImage:  headImage,
-		HeadCPUs:   headCPUs,
-		HeadMemory: headMemory,
-                ConfigName: configName,
-                ParamsFile: paramsFile,
-                CustomFile: customFile,
-                Ttl:        ttl,
+	return a, nil
+}
+
+// launcherOption applies the launcher option at args[i]. It reports whether
+// the option consumed the following argument and whether it was a launcher
+// option at all; anything else is passed on to nextflow run. Invalid values
+// are returned as an error.
+func (a *Args) launcherOption(args []string, i int) (bool, bool, error) {
+	var err error
+	switch args[i] {
+	case "-v":
+		a.Volumes = append(a.Volumes, args[i+1])
+	case "-head-image", "-pod-image":
+		a.HeadImage = args[i+1]
+	case "-head-cpus":
+		a.HeadCPUs , err = quantity(args[i], args[i+1])
+	case "-head-memory":
+		a.HeadMemory , err = quantity(args[i], args[i+1])
+	case "-head-cpu-request":
+		a.HeadCPURequest , err = quantity(args[i], args[i+1])
+	case "-head-cpu-limit":
+		a.HeadCPULimit , err = quantity(args[i], args[i+1])
+	case "-head-memory-request":
+		a.HeadMemoryRequest , err = quantity(args[i], args[i+1])
+	case "-head-memory-limit":
+		a.HeadMemoryLimit , err = quantity(args[i], args[i+1])
+	case "-head-ephemeral-storage":
+		a.HeadEphemeralStorage , err = quantity(args[i], args[i+1])
+	case "-head-node-selector":
+		a.HeadNodeSelector = append(a.HeadNodeSelector, strings.Split(args[i+1], ",")...)
+	case "-head-toleration":
+		a.HeadTolerations = append(a.HeadTolerations, args[i+1])
+	case "-head-affinity-file":
+		a.HeadAffinityFile = args[i+1]
+	case "-head-priority-class":
+		a.HeadPriorityClass = args[i+1]
+	case "-head-runtime-class":
+		a.HeadRuntimeClass = args[i+1]
+	case "-head-pod-template":
+		a.HeadPodTemplate = args[i+1]
+	case "-head-pod-template-force":
+		a.HeadPodTemplateForce = true
+		return false, true, nil
+	case "-dry-run":
+		a.DryRun = true
+		return false, true, nil
+	case "-show-secrets":
+		a.ShowSecrets = true
+		return false, true, nil
+	case "-o":
+		if args[i+1] != "yaml" && args[i+1] != "json" {
+			return true, true, fmt.Errorf("invalid -o value '%s', expected yaml or json", args[i+1])
+		}
+		a.Output = args[i+1]
+	case "-head-no-limits":
+		a.HeadNoLimits = true
+		return false, true, nil
+	case "-on-interrupt":
+		a.OnInterrupt, err = interruptPolicy(args[i+1])
+	case "-fetch-reports":
+		a.FetchDir = args[i+1]
+	case "-ttl":
+		if args[i+1] == "never" {
+			a.Ttl = TtlNever
+			break
+		}
+		ttl, err := seconds(args[i], args[i+1])
+		if err != nil {
+			return true, true, err
+		}
+		if ttl > math.MaxInt32 {
+			return true, true, fmt.Errorf("invalid -ttl value '%s', the maximum is %d seconds", args[i+1], math.MaxInt32)
+		}
+		a.Ttl = int32(ttl)
+	case "-max-runtime":
+		if a.MaxRuntime, err = seconds(args[i], args[i+1]); err == nil && a.MaxRuntime == 0 {
+			return true, true, fmt.Errorf("invalid -max-runtime value '%s', expected a positive duration", args[i+1])
+		}
+	case "-label":
+		if err = keyValue(args[i], args[i+1], true); err == nil {
+			a.Labels = append(a.Labels, args[i+1])
+		}
+	case "-annotation":
+		if err = keyValue(args[i], args[i+1], false); err == nil {
+			a.Annotations = append(a.Annotations, args[i+1])
+		}
+	case "-detach", "-bg":
+		a.Detach = true
+		return false, true, nil
+	case "-keep-record":
+		a.KeepRecord = true
+		return false, true, nil
+	case "-head-retries":
+		retries, err := strconv.Atoi(args[i+1])
+		if err != nil || retries < 0 {
+			return true, true, fmt.Errorf("invalid -head-retries value '%s'", args[i+1])
+		}
+		a.HeadRetries = retries
+	case "-head-retry-memory-factor":
+		factor, err := strconv.ParseFloat(args[i+1], 64)
+		if err != nil || factor < 1 {
+			return true, true, fmt.Errorf("invalid -head-retry-memory-factor value '%s'", args[i+1])
+		}
+		a.HeadMemoryGrowth = factor
+	case "-no-stage-inputs":
+		a.NoStageInputs = true
+		return false, true, nil
+	case "-create-missing-pvcs":
+		a.CreateMissingPVCs = true
+		return false, true, nil
+	case "-skip-preflight":
+		a.SkipPreflight = true
+		return false, true, nil
+	case "-name-template":
+		a.NameTemplate = args[i+1]
+	case "-name", "-head-prescript":
+	case "-C":
+		a.ConfigName = args[i+1]
+	case "-c", "-config":
+		a.CustomFile = args[i+1]
+		filename := filepath.Base(a.CustomFile)
+		a.Nextflow = append(a.Nextflow, "-c", "/etc/nextflow/"+filename)
+	case "-params-file":
+		a.ParamsFile = args[i+1]
+		filename := filepath.Base(a.ParamsFile)
+		a.Nextflow = append(a.Nextflow, "-params-file", "/etc/nextflow/"+filename)
+	default:
+		return false, false, nil
+	}
+	return true, true, err
+}
+
+// quantity checks that the value of a resource option is a Kubernetes
+// quantity.
+func quantity(option, value string) (string, error) {
+	if _, err := resource.ParseQuantity(value); err != nil {
+		return "", fmt.Errorf("invalid %s value '%s': %v", option, value, err)
+	}
+	return value, nil
+}
+
+// seconds parses the value of a duration option, either a number of seconds
+// or a duration such as 90m or 48h.
+func seconds(option, value string) (int64, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+		return n, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s value '%s', expected seconds or a duration such as 90m or 48h", option, value)
 	}
+	return int64(math.Ceil(d.Seconds())), nil
 }
 
+// keyValue checks that the value of a -label or -annotation option is a
+// key=value pair Kubernetes accepts. Keys of the launcher cannot be set.
+func keyValue(option, value string, label bool) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("invalid %s value '%s', expected key=value", option, value)
+	}
+	errs := validation.IsQualifiedName(key)
+	if label {
+		errs = append(errs, validation.IsValidLabelValue(val)...)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid %s value '%s': %s", option, value, strings.Join(errs, "; "))
+	}
+	if key == "app" || key == "runName" || key == "job-name" || strings.HasPrefix(key, "nextflow-go/") {
+		return fmt.Errorf("invalid %s value '%s', %s is set by the launcher", option, value, key)
+	}
+	return nil
+}
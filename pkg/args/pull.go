@@ -0,0 +1,55 @@
+package args
+
+import (
+	"fmt"
+	"os"
+)
+
+type PullArgs struct {
+	RunName    string
+	Path       string
+	Namespace  string
+	ConfigName string
+	Volumes    []string
+	Dest       string
+	Include    []string
+	Exclude    []string
+}
+
+// ParsePullArgs parses `nextflow-go pull <run> [path] [options]`.
+func ParsePullArgs() PullArgs {
+	args := os.Args[2:]
+	pullArgs := PullArgs{ConfigName: "nextflow.config"}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-n", "-namespace":
+			pullArgs.Namespace = value(args, &i)
+		case "-C":
+			pullArgs.ConfigName = value(args, &i)
+		case "-v":
+			pullArgs.Volumes = append(pullArgs.Volumes, value(args, &i))
+		case "-d", "-dir":
+			pullArgs.Dest = value(args, &i)
+		case "-include":
+			pullArgs.Include = append(pullArgs.Include, value(args, &i))
+		case "-exclude":
+			pullArgs.Exclude = append(pullArgs.Exclude, value(args, &i))
+		default:
+			switch {
+			case pullArgs.RunName == "":
+				pullArgs.RunName = args[i]
+			case pullArgs.Path == "":
+				pullArgs.Path = args[i]
+			default:
+				panic(fmt.Sprintf("unexpected argument '%s'", args[i]))
+			}
+		}
+	}
+
+	if pullArgs.RunName == "" {
+		fmt.Println("usage: nextflow-go pull <run> [path] [-v pvc:dir] [-d dir] [-include glob] [-exclude glob]")
+		os.Exit(1)
+	}
+	return pullArgs
+}
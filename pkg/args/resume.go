@@ -0,0 +1,113 @@
+package args
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type ResumeArgs struct {
+	RunName    string
+	Namespace  string
+	ConfigName string
+	Overrides  []string
+}
+
+// ParseResumeArgs parses `nextflow-go resume <run> [options]`. Options other
+// than the namespace are collected as overrides of the recorded run.
+func ParseResumeArgs() ResumeArgs {
+	args := os.Args[2:]
+	resumeArgs := ResumeArgs{ConfigName: "nextflow.config"}
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-n" || args[i] == "-namespace":
+			resumeArgs.Namespace = value(args, &i)
+		case resumeArgs.RunName == "" && !strings.HasPrefix(args[i], "-"):
+			resumeArgs.RunName = args[i]
+		default:
+			resumeArgs.Overrides = append(resumeArgs.Overrides, args[i])
+		}
+	}
+
+	if resumeArgs.RunName == "" {
+		fmt.Println("usage: nextflow-go resume <run> [-n namespace] [launcher or nextflow options to override]")
+		os.Exit(1)
+	}
+	return resumeArgs
+}
+
+// Override applies options given to resume on top of the recorded arguments
+// of a run. Launcher options replace the recorded values, nextflow options
+// replace the value of the same option or are appended.
+func Override(a Args, overrides []string) (Args, error) {
+	a.Nextflow = append([]string{}, a.Nextflow...)
+	a.Volumes = append([]string{}, a.Volumes...)
+	for i := 0; i < len(overrides); i++ {
+		arg := overrides[i]
+		switch arg {
+		case "-v", "-C", "-name", "-name-template":
+			return a, fmt.Errorf("%s cannot be changed when resuming a run", arg)
+		}
+		consumed, ok, err := a.launcherOption(overrides, i)
+		if err != nil {
+			return a, err
+		}
+		if ok {
+			if consumed {
+				i++
+			}
+			continue
+		}
+
+		if i+1 < len(overrides) && !strings.HasPrefix(overrides[i+1], "-") {
+			a.SetOption(arg, overrides[i+1])
+			i++
+		} else if a.optionIndex(arg) < 0 {
+			a.Nextflow = append(a.Nextflow, arg)
+		}
+	}
+	return a, nil
+}
+
+// SetOption sets the value of a nextflow option, replacing the value it had.
+func (a *Args) SetOption(name, value string) {
+	idx := a.optionIndex(name)
+	switch {
+	case idx < 0:
+		a.Nextflow = append(a.Nextflow, name, value)
+	case idx+1 < len(a.Nextflow) && idx+1 != a.PipelineIndex && !strings.HasPrefix(a.Nextflow[idx+1], "-"):
+		a.Nextflow[idx+1] = value
+	default:
+		a.Nextflow = append(a.Nextflow[:idx+1], append([]string{value}, a.Nextflow[idx+1:]...)...)
+		if a.PipelineIndex > idx {
+			a.PipelineIndex++
+		}
+	}
+}
+
+// RemoveOption drops a nextflow option, and its value when hasValue accepts
+// the argument that follows it.
+func (a *Args) RemoveOption(name string, hasValue func(string) bool) {
+	idx := a.optionIndex(name)
+	if idx < 0 {
+		return
+	}
+	n := 1
+	if idx+1 < len(a.Nextflow) && idx+1 != a.PipelineIndex && hasValue(a.Nextflow[idx+1]) {
+		n = 2
+	}
+	a.Nextflow = append(a.Nextflow[:idx], a.Nextflow[idx+n:]...)
+	if a.PipelineIndex > idx {
+		a.PipelineIndex -= n
+	}
+}
+
+func (a *Args) optionIndex(name string) int {
+	for i, arg := range a.Nextflow {
+		if arg == name && i != a.PipelineIndex {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,44 @@
+package args
+
+import (
+	"fmt"
+	"os"
+)
+
+type StatusArgs struct {
+	RunName    string
+	Namespace  string
+	ConfigName string
+	Output     string
+}
+
+// ParseStatusArgs parses `nextflow-go status <run> [options]`.
+func ParseStatusArgs() StatusArgs {
+	args := os.Args[2:]
+	statusArgs := StatusArgs{ConfigName: "nextflow.config"}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-n", "-namespace":
+			statusArgs.Namespace = value(args, &i)
+		case "-C":
+			statusArgs.ConfigName = value(args, &i)
+		case "-o", "-output":
+			statusArgs.Output = value(args, &i)
+			if statusArgs.Output != "json" && statusArgs.Output != "text" {
+				panic(fmt.Sprintf("invalid output format '%s', expected text or json", statusArgs.Output))
+			}
+		default:
+			if statusArgs.RunName != "" {
+				panic(fmt.Sprintf("unexpected argument '%s'", args[i]))
+			}
+			statusArgs.RunName = args[i]
+		}
+	}
+
+	if statusArgs.RunName == "" {
+		fmt.Println("usage: nextflow-go status <run> [-n namespace] [-o text|json]")
+		os.Exit(1)
+	}
+	return statusArgs
+}
@@ -0,0 +1,48 @@
+package args
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+type WaitArgs struct {
+	RunName    string
+	Namespace  string
+	ConfigName string
+	Timeout    time.Duration
+}
+
+// ParseWaitArgs parses `nextflow-go wait <run> [options]`.
+func ParseWaitArgs() WaitArgs {
+	args := os.Args[2:]
+	waitArgs := WaitArgs{
+		ConfigName: "nextflow.config",
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-n", "-namespace":
+			waitArgs.Namespace = value(args, &i)
+		case "-C":
+			waitArgs.ConfigName = value(args, &i)
+		case "-timeout", "--timeout":
+			timeout, err := time.ParseDuration(value(args, &i))
+			if err != nil {
+				panic(fmt.Sprintf("invalid -timeout value: %v", err))
+			}
+			waitArgs.Timeout = timeout
+		default:
+			if waitArgs.RunName != "" {
+				panic(fmt.Sprintf("unexpected argument '%s'", args[i]))
+			}
+			waitArgs.RunName = args[i]
+		}
+	}
+
+	if waitArgs.RunName == "" {
+		fmt.Println("usage: nextflow-go wait <run> [-n namespace] [-timeout duration]")
+		os.Exit(1)
+	}
+	return waitArgs
+}
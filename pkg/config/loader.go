@@ -2,9 +2,12 @@ package config
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
         "nextflow-go/pkg/utils"
 )
@@ -103,6 +106,11 @@ func ReadNextflowConfig(filename string) (map[string]string, string, error) {
 	return parseK8sBlock(k8sBlock), strings.Join(remainingLines, "\n"), nil
 }
 
+// scopeStartRegex matches the start of a nested scope such as `head {`.
+var scopeStartRegex = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)\s*\{$`)
+
+// parseK8sBlock returns the settings of the k8s scope. Settings of nested
+// scopes are returned with the scope as prefix, e.g. head.cpuLimit.
 func parseK8sBlock(lines []string) map[string]string {
 	config := make(map[string]string)
 	var (
@@ -111,6 +119,7 @@ func parseK8sBlock(lines []string) map[string]string {
 		multiLine bool
 		delimiter rune
 		nesting   int
+		scopes    []string
 	)
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -118,11 +127,21 @@ func parseK8sBlock(lines []string) map[string]string {
 			continue
 		}
 		if !multiLine {
+			if match := scopeStartRegex.FindStringSubmatch(line); match != nil {
+				scopes = append(scopes, match[1])
+				continue
+			}
+			if line == "}" {
+				if len(scopes) > 0 {
+					scopes = scopes[:len(scopes)-1]
+				}
+				continue
+			}
 			parts := splitLine(line)
 			if len(parts) != 2 {
 				continue
 			}
-			key = strings.TrimSpace(parts[0])
+			key = strings.Join(append(append([]string{}, scopes...), strings.TrimSpace(parts[0])), ".")
 			val.Reset()
 			val.WriteString(strings.TrimSpace(parts[1]))
 			if isMultilineStart(parts[1]) {
@@ -177,3 +196,19 @@ func handleMultiline(line string, delimiter rune, nesting int, builder *strings.
 	return true, nesting
 }
 
+
+// Hash returns a digest of the k8s config scope that does not depend on the
+// order of its keys.
+func Hash(config map[string]string) string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s=%s\n", key, config[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,54 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"nextflow-go/pkg/args"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Attach follows the output of an already submitted run.
+func Attach() {
+	args := args.ParseAttachArgs()
+	namespace := resolveNamespace(args.Namespace, loadK8sConfig(args.ConfigName))
+
+	clientset, restConfig, err := newClient()
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+
+	job, err := findRun(ctx, clientset, namespace, args.RunName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", job.Name),
+	})
+	if err != nil {
+		panic(err)
+	}
+	if len(pods.Items) == 0 || pods.Items[0].Status.Phase == corev1.PodPending {
+		fmt.Printf("Head pod of run '%s' is pending, waiting for it to start...\n", job.Name)
+	}
+
+	handleInterrupts(ctx, clientset, restConfig, namespace, job.Name, args.OnInterrupt)
+	podName := waitForPod(ctx, clientset, namespace, job.Name)
+
+	var tail *int64
+	if args.Tail >= 0 {
+		tail = &args.Tail
+	}
+	if podName != "" {
+		streamLogs(ctx, clientset, namespace, podName, tail)
+	}
+	exitCode := reportExit(ctx, clientset, namespace, job.Name)
+	recordExit(ctx, clientset, job, exitCode)
+	os.Exit(exitCode)
+}
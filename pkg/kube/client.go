@@ -0,0 +1,65 @@
+package kube
+
+import (
+	"os"
+	"strings"
+
+	"nextflow-go/pkg/config"
+	"nextflow-go/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func getKubeConfig() (*rest.Config, error) {
+	if config, err := rest.InClusterConfig(); err == nil {
+		return config, nil
+	}
+	return clientcmd.BuildConfigFromFlags("", os.Getenv("HOME")+"/.kube/config")
+}
+
+func newClient() (*kubernetes.Clientset, *rest.Config, error) {
+	restConfig, err := getKubeConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientset, restConfig, nil
+}
+
+// resolveNamespace picks the namespace given on the command line, then the
+// one from the k8s config scope, then the service account namespace when
+// running inside a pod.
+func resolveNamespace(namespace string, k8sConfig map[string]string) string {
+	if namespace != "" {
+		return namespace
+	}
+	namespace = corev1.NamespaceDefault
+	if nsBytes, err := os.ReadFile("/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		if ns := strings.TrimSpace(string(nsBytes)); ns != "" {
+			namespace = ns
+		}
+	}
+	if ns, ok := k8sConfig["namespace"]; ok {
+		namespace = utils.Stripped(ns)
+	}
+	return namespace
+}
+
+// loadK8sConfig reads the k8s scope of the config file for commands that
+// work with existing runs. A missing or unreadable file yields an empty scope.
+func loadK8sConfig(configName string) map[string]string {
+	k8sConfig, _, err := config.ReadNextflowConfig(configName)
+	if err != nil {
+		return map[string]string{}
+	}
+	if normalized, err := config.NormalizeK8sConfig(k8sConfig); err == nil {
+		return normalized
+	}
+	return k8sConfig
+}
@@ -2,15 +2,17 @@ package kube
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
         "path/filepath"
 	"strconv"
 	"strings"
-        "time"
 
         "nextflow-go/pkg/args"
         "nextflow-go/pkg/config"
+        "nextflow-go/pkg/stage"
         "nextflow-go/pkg/utils"
 
 	batchv1 "k8s.io/api/batch/v1"
@@ -18,12 +20,29 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/rest"
 )
 
-func Execute(dryRun bool) {
-	args := args.ParseArgs()
+func Execute() {
+	args, err := args.ParseArgs()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+        // A dry run prints the manifest on stdout and everything else on
+        // stderr, so the output can be piped into kubectl apply. A detached
+        // run prints only its name on stdout.
+        var dryRun *manifest
+        stdout := os.Stdout
+        if args.DryRun {
+                dryRun = &manifest{ShowSecrets: args.ShowSecrets}
+        }
+        if args.DryRun || args.Detach {
+                os.Stdout = os.Stderr
+        }
+        fmt.Println("Running Nextflow K8s Job...")
+
 	k8sConfig, restConfigStr, err := config.ReadNextflowConfig(args.ConfigName)
         if err != nil {
                 panic(err)
@@ -32,26 +51,22 @@ func Execute(dryRun bool) {
         if err != nil {
                 panic(err)
         }
+        configHash := config.Hash(k8sConfig)
 
-	volumes := utils.NormalizeVolumes(args.Volumes, k8sConfig)
-	config, err := getKubeConfig()
+	volumes, err := utils.NormalizeVolumes(args.Volumes, k8sConfig)
 	if err != nil {
-		panic(err)
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil && !dryRun {
+	clientset, restConfig, err := newClient()
+	if err != nil && dryRun == nil {
 		panic(err)
 	}
 
-	namespace := corev1.NamespaceDefault
-        if nsBytes, err := os.ReadFile("/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
-                if ns := strings.TrimSpace(string(nsBytes)); ns != "" {
-                        namespace = ns
-                }
+	namespace := resolveNamespace("", k8sConfig)
+        if dryRun != nil {
+                dryRun.Namespace = namespace
         }
-	if ns, ok := k8sConfig["namespace"]; ok {
-		namespace = strings.Trim(ns, "'\"")
-	}
 
 	launchDir, _ := os.Getwd()
 	if dir, ok := k8sConfig["launchDir"]; ok {
@@ -60,31 +75,82 @@ func Execute(dryRun bool) {
                 k8sConfig["launchDir"] = launchDir
         }
 
+        ctx := context.Background()
+        runName, err := candidateRunName(args)
+        if err != nil {
+                panic(err)
+        }
+        if dryRun == nil {
+                if runName, err = uniqueRunName(ctx, clientset, namespace, runName, nil); err != nil {
+                        panic(err)
+                }
+        }
+        args.SetRunName(runName)
+
+        if err := provisionVolumes(ctx, clientset, namespace, args.JobName, missingVolumes(args.Volumes, volumes, args.CreateMissingPVCs), dryRun); err != nil {
+                panic(err)
+        }
+
         if k8sConfig["computeResourceType"] == "" {
                 fmt.Printf("computeResourceType not defined in configuration, defaulting to Job\n")
                 k8sConfig["computeResourceType"] = "'Job'"
         }
-	finalConfig := utils.PrepareFinalConfig(k8sConfig, restConfigStr)
-
-        serviceAccount := "default"
-        if k8sConfig["serviceAccount"] != "" {
-                serviceAccount = utils.Stripped(k8sConfig["serviceAccount"])
+        affinity, err := loadAffinity(args, k8sConfig)
+        if err != nil {
+                panic(err)
+        }
+        if !args.SkipPreflight && dryRun == nil {
+                head := corev1.PodSpec{
+                        Affinity:          affinity,
+                        PriorityClassName: headPriorityClass(args, k8sConfig),
+                        Containers:        []corev1.Container{{Resources: prepareResources(args, k8sConfig)}},
+                }
+                if args.MaxRuntime > 0 {
+                        head.ActiveDeadlineSeconds = &args.MaxRuntime
+                }
+                preflight(ctx, clientset, restConfig, namespace, k8sConfig, volumes, launchDir, head, args.KeepRecord)
         }
 
-        pullPolicy := corev1.PullAlways
-        if k8sConfig["pullPolicy"] != "" && utils.Stripped(k8sConfig["pullPolicy"]) == "IfNotPresent" {
-                pullPolicy = corev1.PullIfNotPresent
+        podVolumes, mounts, err := utils.BuildVolumes(volumes)
+        if err != nil {
+                fmt.Println(err)
+                os.Exit(1)
         }
-       
-        runAsUser := 1000
-        if k8sConfig["runAsUser"] != "" {
-                runAsUser, _ = strconv.Atoi(k8sConfig["runAsUser"])
+        if dryRun == nil && onVolume(launchDir, podVolumes, mounts, false) {
+                // Nextflow refuses a run name that is in the history of the
+                // launch directory.
+                history, err := readHistory(ctx, clientset, restConfig, namespace, helperSpec{
+                        RunName: args.JobName, Purpose: "history", Image: args.HeadImage, RunAsUser: runAsUser(k8sConfig), Volumes: podVolumes, Mounts: mounts,
+                }, launchDir)
+                if err != nil {
+                        fmt.Printf("Unable to read the Nextflow history, the run name is not checked against it: %v\n", err)
+                } else if runName, err := uniqueRunName(ctx, clientset, namespace, args.JobName, history); err != nil {
+                        panic(err)
+                } else {
+                        args.SetRunName(runName)
+                }
+        }
+
+        // Worker pods are labelled with the run only in the final config,
+        // the recorded k8s scope stays as configured.
+        workerLabels, workerAnnotations := workerMetadata(args)
+        workerPod, err := utils.LabelWorkerPods(k8sConfig["pod"], workerLabels, workerAnnotations)
+        if err != nil {
+                fmt.Println(err)
+                os.Exit(1)
         }
+        workerConfig := maps.Clone(k8sConfig)
+        workerConfig["pod"] = workerPod
+	finalConfig := utils.PrepareFinalConfig(workerConfig, restConfigStr)
 
 	initScript := fmt.Sprintf("mkdir -p '%s'; cd '%s'; cp /etc/nextflow/nextflow.config .", launchDir, launchDir)
 
+        st := stage.New(stageDir(launchDir, args.JobName))
+        if err := stageLocalPipeline(&args, st); err != nil {
+                panic(err)
+        }
+
         data := map[string][]byte{
-	        "init.sh":         []byte(initScript),
 	        "nextflow.config": []byte(finalConfig),
         }
 
@@ -108,29 +174,107 @@ func Execute(dryRun bool) {
                 data[filename] = content
         }
 
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{GenerateName: "nf-config-"},
-		Type:       corev1.SecretTypeOpaque,
-		Data:       data, 
-	}
+        if !args.NoStageInputs {
+                if err := stageInputs(&args, st, data); err != nil {
+                        panic(err)
+                }
+        }
 
-        secretName := "nf-config-"
-        ctx := context.Background()
+        stageHelper := helperSpec{RunName: args.JobName, Purpose: "stage", Image: args.HeadImage, RunAsUser: runAsUser(k8sConfig), Volumes: podVolumes, Mounts: mounts}
+        unpack, err := uploadStage(ctx, clientset, restConfig, namespace, st, stageHelper, data, dryRun != nil)
+        if err != nil {
+                panic(err)
+        }
+        if unpack != "" {
+                initScript += "; " + unpack
+        }
+        data["init.sh"] = []byte(initScript)
 
-        if ! dryRun {
-  	        createdSecret, err := clientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
-	        if err != nil {
-		        panic(err)
-	        }
-	        secretName = createdSecret.Name
-        } else {
-                utils.PrintAsJSON(secret)
+        var podTemplate string
+        if args.HeadPodTemplate != "" {
+                podTemplate, err = loadPodTemplate(args.HeadPodTemplate)
+                if err != nil {
+                        panic(err)
+                }
+        }
+
+        plan := &launchPlan{
+                launchSpec: launchSpec{
+                        Args:        args,
+                        K8sConfig:   k8sConfig,
+                        ConfigHash:  configHash,
+                        Volumes:     volumes,
+                        LaunchDir:   launchDir,
+                        Affinity:    affinity,
+                        PodTemplate: podTemplate,
+                },
+                Namespace: namespace,
+                Data:      data,
+        }
+        submit(ctx, clientset, restConfig, plan, dryRun)
+
+        if dryRun != nil {
+                if err := dryRun.print(stdout, args.Output); err != nil {
+                        panic(err)
+                }
+                if keys := dryRun.redactedKeys(); len(keys) > 0 {
+                        fmt.Printf("The values of %s in the config secret are redacted, pass -show-secrets to include them.\n", strings.Join(keys, ", "))
+                }
+        } else if args.Detach {
+                fmt.Fprintln(stdout, args.JobName)
+        }
+}
+
+// submit creates the config secret and the head job of the plan and follows
+// the output of the run, unless it is detached. In a dry run they are added
+// to the manifest instead.
+func submit(ctx context.Context, clientset *kubernetes.Clientset, restConfig *rest.Config, plan *launchPlan, dryRun *manifest) {
+        args := plan.Args
+        k8sConfig := plan.K8sConfig
+        namespace := plan.Namespace
+
+        // Check the volumes before anything of the run is created.
+        if _, _, err := utils.BuildVolumes(plan.Volumes); err != nil {
+                fmt.Println(err)
+                os.Exit(1)
+        }
+
+        serviceAccount := "default"
+        if k8sConfig["serviceAccount"] != "" {
+                serviceAccount = utils.Stripped(k8sConfig["serviceAccount"])
+        }
+
+        pullPolicy := corev1.PullAlways
+        if k8sConfig["pullPolicy"] != "" && utils.Stripped(k8sConfig["pullPolicy"]) == "IfNotPresent" {
+                pullPolicy = corev1.PullIfNotPresent
+        }
+
+        // A manifest printed by a dry run is applied as a regular run.
+        recorded := plan.launchSpec
+        recorded.Args.DryRun, recorded.Args.ShowSecrets, recorded.Args.Detach = false, false, false
+        spec, err := json.Marshal(recorded)
+        if err != nil {
+                panic(err)
+        }
+
+        var ttl *int32
+        if args.Ttl >= 0 {
+                ttl = &args.Ttl
+        }
+        var maxRuntime *int64
+        if args.MaxRuntime > 0 {
+                maxRuntime = &args.MaxRuntime
+        }
+
+        var podFailurePolicy *batchv1.PodFailurePolicy
+        if args.HeadRetries > 0 {
+                podFailurePolicy = headFailurePolicy(args.JobName)
         }
 
 	mainCmd := fmt.Sprintf("source /etc/nextflow/init.sh; nextflow run %s", strings.Join(args.Nextflow, " "))
 	command := []string{"/bin/bash", "-c", mainCmd}
 
-	resources := prepareResources(args.HeadCPUs, args.HeadMemory)
+	resources := prepareResources(args, k8sConfig)
 	envVars := prepareEnvVars(k8sConfig)
 
 	job := &batchv1.Job{
@@ -140,12 +284,18 @@ func Execute(dryRun bool) {
 				"app":     "nextflow",
 				"runName": args.JobName,
 			},
+			Annotations: map[string]string{
+				annotationLaunchDir:  plan.LaunchDir,
+				annotationLaunchSpec: string(spec),
+			},
 		},
 		Spec: batchv1.JobSpec{
                         BackoffLimit: utils.Int32Ptr(0),
-                        TTLSecondsAfterFinished: &args.Ttl,
+                        PodFailurePolicy: podFailurePolicy,
+                        TTLSecondsAfterFinished: ttl,
+                        ActiveDeadlineSeconds: maxRuntime,
 			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"job-name": args.JobName}},
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"job-name": args.JobName, "runName": args.JobName}},
 				Spec: corev1.PodSpec{
                                         ServiceAccountName: serviceAccount,
 					RestartPolicy: corev1.RestartPolicyNever,
@@ -161,15 +311,64 @@ func Execute(dryRun bool) {
 						Command:         command,
 						Resources:       resources,
 						Env:             envVars,
-						SecurityContext: &corev1.SecurityContext{RunAsUser: utils.Int64Ptr(int64(runAsUser)), AllowPrivilegeEscalation: utils.BoolPtr(false), Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}},
+						SecurityContext: &corev1.SecurityContext{RunAsUser: utils.Int64Ptr(runAsUser(k8sConfig)), AllowPrivilegeEscalation: utils.BoolPtr(false), Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}},
 					}}},
 			},
 		},
 	}
 
-	utils.AttachVolumesToJob(job, volumes, secretName)
+        // The run metadata and the -label and -annotation values go on both
+        // the job and its pod.
+        metadata := runMetadata(args, plan.Data["nextflow.config"])
+        podMeta := &job.Spec.Template.ObjectMeta
+        podMeta.Annotations = make(map[string]string)
+        for _, values := range []map[string]string{metadata, keyValues(args.Annotations)} {
+                for key, value := range values {
+                        job.Annotations[key], podMeta.Annotations[key] = value, value
+                }
+        }
+        for key, value := range keyValues(args.Labels) {
+                job.Labels[key], podMeta.Labels[key] = value, value
+        }
+
+        if err := applyScheduling(&job.Spec.Template.Spec, args, k8sConfig, plan.Affinity); err != nil {
+                panic(err)
+        }
 
-        if ! dryRun {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "nf-config-"},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       plan.Data,
+	}
+        if args.KeepRecord {
+                secret.Labels = recordLabels(args.JobName)
+        }
+
+        var secretName string
+
+        if dryRun == nil {
+  	        createdSecret, err := clientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	        if err != nil {
+		        panic(err)
+	        }
+	        secretName = createdSecret.Name
+        } else {
+                secretName = "nf-config-" + args.JobName
+                secret.GenerateName, secret.Name = "", secretName
+                dryRun.add(secret, "v1", "Secret")
+        }
+
+	if err := utils.AttachVolumesToJob(job, plan.Volumes, secretName); err != nil {
+		panic(err)
+	}
+        if plan.PodTemplate != "" {
+                job, err = applyPodTemplate(job, plan.PodTemplate, args.HeadPodTemplateForce)
+                if err != nil {
+                        panic(err)
+                }
+        }
+
+        if dryRun == nil {
  	        createdJob, err := clientset.BatchV1().Jobs(namespace).Create(ctx, job, metav1.CreateOptions{})
 	        if err != nil {
 		        panic(err)
@@ -180,6 +379,16 @@ func Execute(dryRun bool) {
                         panic(err)
                 }
                 
+                if args.KeepRecord {
+                        record, err := runRecord(createdJob)
+                        if err == nil {
+                                _, err = clientset.CoreV1().ConfigMaps(namespace).Create(ctx, record, metav1.CreateOptions{})
+                        }
+                        if err != nil {
+                                fmt.Printf("Unable to record run '%s', it will not be listed after the job is removed: %v\n", createdJob.Name, err)
+                        }
+                }
+
                 ownerRef := metav1.OwnerReference{
                         APIVersion:         "batch/v1",
                         Kind:               "Job",
@@ -189,80 +398,125 @@ func Execute(dryRun bool) {
                         BlockOwnerDeletion: utils.BoolPtr(true),
                 }
  
-                existingSecret.ObjectMeta.OwnerReferences = []metav1.OwnerReference{ownerRef}
-
-                _, err = clientset.CoreV1().Secrets(namespace).Update(ctx, existingSecret, metav1.UpdateOptions{})
-                if err != nil {
-                        panic(err)
-                }
+                if !args.KeepRecord {
+                        existingSecret.ObjectMeta.OwnerReferences = []metav1.OwnerReference{ownerRef}
 
-                var podName string
-                for {
-                        pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-                                        LabelSelector: fmt.Sprintf("job-name=%s", createdJob.Name),
-                                     })
+                        _, err = clientset.CoreV1().Secrets(namespace).Update(ctx, existingSecret, metav1.UpdateOptions{})
                         if err != nil {
-                                fmt.Printf("Error listing pods: %v\n", err)
-                                time.Sleep(2 * time.Second)
-                                continue
+                                panic(err)
                         }
+                }
 
-                        if len(pods.Items) > 0 && pods.Items[0].Status.Phase != corev1.PodPending {
-                                podName = pods.Items[0].Name
-                                break
+                if args.Detach {
+                        fmt.Printf("Run '%s' submitted in namespace '%s'. Follow it with 'nextflow-go attach %s' or wait for it with 'nextflow-go wait %s'.\n", createdJob.Name, namespace, createdJob.Name, createdJob.Name)
+                        if args.HeadRetries > 0 || args.FetchDir != "" {
+                                fmt.Println("Warning: -head-retries and -fetch-reports need the launcher to follow the run and are ignored with -detach")
                         }
-
-                        time.Sleep(2 * time.Second)
+                        return
                 }
-                logOpts := &corev1.PodLogOptions{
-                                Follow: true,
-                           }
 
-                req := clientset.CoreV1().Pods(namespace).GetLogs(podName, logOpts)
-                stream, err := req.Stream(ctx)
-                if err != nil {
-                        fmt.Printf("Error streaming logs: %v\n", err)
-                        return
+                stopInterrupts := handleInterrupts(ctx, clientset, restConfig, namespace, createdJob.Name, args.OnInterrupt)
+                podName := waitForPod(ctx, clientset, namespace, createdJob.Name)
+                if podName != "" {
+                        streamLogs(ctx, clientset, namespace, podName, nil)
                 }
-                defer stream.Close()
-
-                fmt.Printf("--- Output from pod %s ---\n", podName)
-                buf := make([]byte, 2000)
-                for {
-                        n, err := stream.Read(buf)
-                        if n > 0 {
-                                os.Stdout.Write(buf[:n])
+                exitCode := reportExit(ctx, clientset, namespace, createdJob.Name)
+                recordExit(ctx, clientset, createdJob, exitCode)
+                if exitCode != 0 && args.HeadRetries > 0 {
+                        if next := retryPlan(ctx, clientset, restConfig, createdJob, plan); next != nil {
+                                stopInterrupts()
+                                submit(ctx, clientset, restConfig, next, dryRun)
                         }
-                        if err != nil {
-                                break
+                }
+                if args.FetchDir != "" {
+                        if err := fetchRunFiles(ctx, clientset, restConfig, namespace, createdJob, args.FetchDir); err != nil {
+                                fmt.Printf("Error fetching files of run '%s': %v\n", createdJob.Name, err)
                         }
                 }
+                os.Exit(exitCode)
         } else {
-                utils.PrintAsJSON(job)
+                dryRun.add(job, "batch/v1", "Job")
+                if args.KeepRecord {
+                        record, err := runRecord(job)
+                        if err != nil {
+                                panic(err)
+                        }
+                        dryRun.add(record, "v1", "ConfigMap")
+                }
         }
+}
 
-	fmt.Printf("Kubernetes Job '%s' created successfully.\n", args.JobName)
+func runAsUser(k8sConfig map[string]string) int64 {
+        runAsUser := 1000
+        if k8sConfig["runAsUser"] != "" {
+                runAsUser, _ = strconv.Atoi(k8sConfig["runAsUser"])
+        }
+        return int64(runAsUser)
 }
 
-func getKubeConfig() (*rest.Config, error) {
-	if config, err := rest.InClusterConfig(); err == nil {
-		return config, nil
+// prepareResources returns the resources of the head container. Options of
+// the launcher take precedence over the k8s.head scope of the config. By
+// default the CPU request is half of the limit and the memory request equals
+// the limit.
+func prepareResources(a args.Args, k8sConfig map[string]string) corev1.ResourceRequirements {
+	setting := func(value, key string) string {
+		if value != "" {
+			return value
+		}
+		return utils.Stripped(k8sConfig["head."+key])
+	}
+	parse := func(name, value string) resource.Quantity {
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			panic(fmt.Sprintf("invalid head %s '%s': %v", name, value, err))
+		}
+		return q
+	}
+
+	cpuLimit := parse("cpus", a.HeadCPUs)
+	if value := setting(a.HeadCPULimit, "cpuLimit"); value != "" {
+		cpuLimit = parse("cpu limit", value)
+	}
+	cpuRequest := *resource.NewMilliQuantity(cpuLimit.MilliValue()/2, resource.DecimalSI)
+	if value := setting(a.HeadCPURequest, "cpuRequest"); value != "" {
+		cpuRequest = parse("cpu request", value)
+	}
+
+	memoryLimit := parse("memory", a.HeadMemory)
+	if value := setting(a.HeadMemoryLimit, "memoryLimit"); value != "" {
+		memoryLimit = parse("memory limit", value)
+	}
+	memoryRequest := memoryLimit
+	if value := setting(a.HeadMemoryRequest, "memoryRequest"); value != "" {
+		memoryRequest = parse("memory request", value)
 	}
-	return clientcmd.BuildConfigFromFlags("", os.Getenv("HOME")+"/.kube/config")
-}
 
-func prepareResources(cpus, memory string) corev1.ResourceRequirements {
-	cpusFloat, _ := strconv.ParseFloat(cpus, 64)
-	return corev1.ResourceRequirements{
+	resources := corev1.ResourceRequirements{
 		Limits: corev1.ResourceList{
-			corev1.ResourceCPU:    resource.MustParse(cpus),
-			corev1.ResourceMemory: resource.MustParse(memory),
+			corev1.ResourceCPU:    cpuLimit,
+			corev1.ResourceMemory: memoryLimit,
 		},
 		Requests: corev1.ResourceList{
-			corev1.ResourceCPU:    resource.MustParse(fmt.Sprintf("%.1f", cpusFloat/2)),
-			corev1.ResourceMemory: resource.MustParse(memory),
+			corev1.ResourceCPU:    cpuRequest,
+			corev1.ResourceMemory: memoryRequest,
 		},
 	}
+	if value := setting(a.HeadEphemeralStorage, "ephemeralStorage"); value != "" {
+		storage := parse("ephemeral storage", value)
+		resources.Limits[corev1.ResourceEphemeralStorage] = storage
+		resources.Requests[corev1.ResourceEphemeralStorage] = storage
+	}
+
+	if a.HeadNoLimits || utils.Stripped(k8sConfig["head.noLimits"]) == "true" {
+		resources.Limits = nil
+		return resources
+	}
+	for name, request := range resources.Requests {
+		if limit := resources.Limits[name]; request.Cmp(limit) > 0 {
+			panic(fmt.Sprintf("head %s request %s exceeds its limit %s", name, request.String(), limit.String()))
+		}
+	}
+	return resources
 }
 
 func prepareEnvVars(config map[string]string) []corev1.EnvVar {
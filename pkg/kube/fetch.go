@@ -0,0 +1,108 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"nextflow-go/pkg/args"
+	"nextflow-go/pkg/utils"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// reportOptions maps the Nextflow report options to the file names Nextflow
+// uses when the option is given without a file.
+var reportOptions = map[string][]string{
+	"-with-report":   {"report.html", "report-*.html"},
+	"-with-timeline": {"timeline.html", "timeline-*.html"},
+	"-with-trace":    {"trace.txt", "trace-*.txt"},
+	"-with-dag":      {"dag.html", "dag-*.html", "dag.dot", "dag-*.dot"},
+}
+
+// Fetch copies .nextflow.log and the report files of a run to a local directory.
+func Fetch() {
+	args := args.ParseFetchArgs()
+	namespace := resolveNamespace(args.Namespace, loadK8sConfig(args.ConfigName))
+
+	clientset, restConfig, err := newClient()
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+
+	job, err := findRun(ctx, clientset, namespace, args.RunName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := fetchRunFiles(ctx, clientset, restConfig, namespace, job, args.Dir); err != nil {
+		fmt.Printf("Error fetching files of run '%s': %v\n", job.Name, err)
+		os.Exit(1)
+	}
+}
+
+// fetchRunFiles copies the log and reports from the launch directory of the
+// run into dir, using a helper pod that mounts the volumes of the head pod.
+func fetchRunFiles(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace string, job *batchv1.Job, dir string) error {
+	launchDir := job.Annotations[annotationLaunchDir]
+	if launchDir == "" {
+		return fmt.Errorf("the launch directory of the run is not recorded")
+	}
+
+	fmt.Printf("Fetching log and reports of run '%s' into %s...\n", job.Name, dir)
+	helper, err := startHelperPod(ctx, clientset, namespace, helperSpecFromJob(job, "fetch"))
+	if err != nil {
+		return err
+	}
+	defer deleteHelperPod(clientset, namespace, helper.Name)
+
+	patterns := runFilePatterns(strings.Fields(job.Annotations[annotationArgs]))
+	script := fmt.Sprintf(`cd %s && for f in %s; do [ -f "$f" ] && echo "$f"; done | tar cf - -T -`,
+		utils.ShellQuote(launchDir), strings.Join(patterns, " "))
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	reader, writer := io.Pipe()
+	extracted := make(chan []string)
+	go func() {
+		files, err := utils.ExtractTar(reader, dir)
+		reader.CloseWithError(err)
+		extracted <- files
+	}()
+	err = execInPod(ctx, clientset, restConfig, namespace, helper.Name, "helper", []string{"/bin/sh", "-c", script}, nil, writer, os.Stderr)
+	writer.CloseWithError(err)
+	files := <-extracted
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		fmt.Printf("  %s\n", file)
+	}
+	fmt.Printf("Fetched %d files.\n", len(files))
+	return nil
+}
+
+// runFilePatterns returns shell words matching .nextflow.log and the report
+// files requested in the nextflow arguments, relative to the launch directory.
+func runFilePatterns(nextflowArgs []string) []string {
+	patterns := []string{".nextflow.log"}
+	for i, arg := range nextflowArgs {
+		defaults, ok := reportOptions[arg]
+		if !ok {
+			continue
+		}
+		if i+1 < len(nextflowArgs) && !strings.HasPrefix(nextflowArgs[i+1], "-") {
+			patterns = append(patterns, utils.ShellQuote(nextflowArgs[i+1]))
+		} else {
+			patterns = append(patterns, defaults...)
+		}
+	}
+	return patterns
+}
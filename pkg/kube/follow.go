@@ -0,0 +1,163 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// waitForPod blocks until the head pod of the job has left the Pending phase
+// and returns its name. Reasons why the job cannot create the pod, such as
+// an exceeded quota, are printed while waiting. When the job finishes or is
+// deleted without a started pod, the reason is printed and "" is returned.
+func waitForPod(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) string {
+	reported := make(map[string]bool)
+	for {
+		pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("job-name=%s", jobName),
+		})
+		if err != nil {
+			fmt.Printf("Error listing pods: %v\n", err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
+
+		if len(pods.Items) > 0 && pods.Items[0].Status.Phase != corev1.PodPending {
+			return pods.Items[0].Name
+		}
+		if len(pods.Items) == 0 {
+			reportFailedCreate(ctx, clientset, namespace, jobName, reported)
+		}
+		if jobEnded(ctx, clientset, namespace, jobName) {
+			return ""
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// jobEnded reports whether the job has completed, failed or was deleted, and
+// prints why.
+func jobEnded(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) bool {
+	job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		fmt.Printf("Job '%s' was deleted.\n", jobName)
+		return true
+	}
+	if err != nil || !jobFinished(job) {
+		return false
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			fmt.Printf("Job '%s' failed: %s %s\n", jobName, cond.Reason, cond.Message)
+		}
+	}
+	return true
+}
+
+// streamLogs copies the log of the pod to stdout until the container stops.
+// When tail is set, only that many of the already written lines are replayed.
+func streamLogs(ctx context.Context, clientset kubernetes.Interface, namespace, podName string, tail *int64) {
+	logOpts := &corev1.PodLogOptions{
+		Follow:    true,
+		TailLines: tail,
+	}
+
+	req := clientset.CoreV1().Pods(namespace).GetLogs(podName, logOpts)
+	stream, err := req.Stream(ctx)
+	if err != nil {
+		fmt.Printf("Error streaming logs: %v\n", err)
+		return
+	}
+	defer stream.Close()
+
+	fmt.Printf("--- Output from pod %s ---\n", podName)
+	buf := make([]byte, 2000)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			os.Stdout.Write(buf[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+}
+
+// headExitCode waits until the head container of the job has terminated and
+// returns its exit code.
+func headExitCode(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) int {
+	for {
+		pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("job-name=%s", jobName),
+		})
+		if err != nil {
+			fmt.Printf("Error listing pods: %v\n", err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		if len(pods.Items) == 0 {
+			fmt.Printf("Head pod of run '%s' no longer exists\n", jobName)
+			return 1
+		}
+
+		pod := pods.Items[0]
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == jobName && status.State.Terminated != nil {
+				return int(status.State.Terminated.ExitCode)
+			}
+		}
+		if pod.Status.Phase == corev1.PodFailed {
+			fmt.Printf("Head pod %s failed: %s %s\n", pod.Name, pod.Status.Reason, pod.Status.Message)
+			return 1
+		}
+		if jobEnded(ctx, clientset, namespace, jobName) {
+			return 1
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// reportExit reports the outcome of the run and returns the exit code of
+// Nextflow in the head pod.
+func reportExit(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) int {
+	exitCode := headExitCode(ctx, clientset, namespace, jobName)
+	if exitCode == 0 {
+		fmt.Printf("Run '%s' completed successfully.\n", jobName)
+	} else {
+		fmt.Printf("Run '%s' failed with exit code %d.\n", jobName, exitCode)
+		if job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{}); err == nil {
+			for _, cond := range job.Status.Conditions {
+				if cond.Type == batchv1.JobFailed && cond.Reason == batchv1.JobReasonDeadlineExceeded && job.Spec.ActiveDeadlineSeconds != nil {
+					fmt.Printf("The run exceeded its maximum runtime of %ds (-max-runtime).\n", *job.Spec.ActiveDeadlineSeconds)
+				}
+			}
+		}
+	}
+	return exitCode
+}
+
+// reportFailedCreate prints the FailedCreate events of the job that were not
+// reported yet.
+func reportFailedCreate(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string, reported map[string]bool) {
+	events, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.kind=Job,involvedObject.name=%s,reason=FailedCreate", jobName),
+	})
+	if err != nil {
+		return
+	}
+	for _, event := range events.Items {
+		if !reported[event.Message] {
+			reported[event.Message] = true
+			fmt.Printf("Job '%s' cannot create the head pod yet: %s\n", jobName, event.Message)
+		}
+	}
+}
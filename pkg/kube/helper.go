@@ -0,0 +1,122 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nextflow-go/pkg/utils"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// helperPodTimeout bounds how long a helper pod may take to start.
+const helperPodTimeout = 5 * time.Minute
+
+// helperSpec describes a short-lived pod that mounts the volumes of a run so
+// that files can be copied to and from them through the exec API.
+type helperSpec struct {
+	RunName   string
+	Purpose   string
+	Image     string
+	RunAsUser int64
+	Volumes   []corev1.Volume
+	Mounts    []corev1.VolumeMount
+}
+
+// helperSpecFromJob builds a helper with the same image, user and volumes as
+// the head pod of the job. The config secret is not mounted.
+func helperSpecFromJob(job *batchv1.Job, purpose string) helperSpec {
+	spec := helperSpec{RunName: job.Name, Purpose: purpose, RunAsUser: 1000}
+	podSpec := job.Spec.Template.Spec
+	for _, vol := range podSpec.Volumes {
+		if vol.Name != "nextflow-config" {
+			spec.Volumes = append(spec.Volumes, vol)
+		}
+	}
+	if len(podSpec.Containers) > 0 {
+		head := podSpec.Containers[0]
+		spec.Image = head.Image
+		if head.SecurityContext != nil && head.SecurityContext.RunAsUser != nil {
+			spec.RunAsUser = *head.SecurityContext.RunAsUser
+		}
+		for _, mount := range head.VolumeMounts {
+			if mount.Name != "nextflow-config" {
+				spec.Mounts = append(spec.Mounts, mount)
+			}
+		}
+	}
+	return spec
+}
+
+// startHelperPod creates the helper pod and waits until it is running.
+func startHelperPod(ctx context.Context, clientset kubernetes.Interface, namespace string, spec helperSpec) (*corev1.Pod, error) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s-%s-", spec.RunName, spec.Purpose),
+			Labels: map[string]string{
+				"app":     "nextflow-go-helper",
+				"runName": spec.RunName,
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   utils.BoolPtr(true),
+				SeccompProfile: &corev1.SeccompProfile{Type: "RuntimeDefault"},
+			},
+			Volumes: spec.Volumes,
+			Containers: []corev1.Container{{
+				Name:         "helper",
+				Image:        spec.Image,
+				Command:      []string{"sleep", "3600"},
+				VolumeMounts: spec.Mounts,
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("1"),
+						corev1.ResourceMemory: resource.MustParse("512Mi"),
+					},
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("100m"),
+						corev1.ResourceMemory: resource.MustParse("512Mi"),
+					},
+				},
+				SecurityContext: &corev1.SecurityContext{RunAsUser: utils.Int64Ptr(spec.RunAsUser), AllowPrivilegeEscalation: utils.BoolPtr(false), Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}},
+			}},
+		},
+	}
+
+	created, err := clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(helperPodTimeout)
+	for time.Now().Before(deadline) {
+		current, err := clientset.CoreV1().Pods(namespace).Get(ctx, created.Name, metav1.GetOptions{})
+		if err == nil {
+			switch current.Status.Phase {
+			case corev1.PodRunning:
+				return current, nil
+			case corev1.PodFailed, corev1.PodSucceeded:
+				deleteHelperPod(clientset, namespace, created.Name)
+				return nil, fmt.Errorf("helper pod %s stopped unexpectedly: %s", created.Name, current.Status.Message)
+			}
+		}
+		time.Sleep(2 * time.Second)
+	}
+	deleteHelperPod(clientset, namespace, created.Name)
+	return nil, fmt.Errorf("helper pod %s did not start within %s", created.Name, helperPodTimeout)
+}
+
+// deleteHelperPod removes the helper pod without waiting for it to stop.
+func deleteHelperPod(clientset kubernetes.Interface, namespace, name string) {
+	err := clientset.CoreV1().Pods(namespace).Delete(context.Background(), name, metav1.DeleteOptions{GracePeriodSeconds: utils.Int64Ptr(0)})
+	if err != nil {
+		fmt.Printf("Error deleting helper pod %s: %v\n", name, err)
+	}
+}
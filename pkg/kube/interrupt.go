@@ -0,0 +1,162 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	InterruptDetach = "detach"
+	InterruptCancel = "cancel"
+)
+
+// workerSelector matches the pods and jobs Nextflow starts for the run.
+func workerSelector(runName string) string {
+	return fmt.Sprintf("nextflow.io/runName=%s", runName)
+}
+
+// handleInterrupts installs the Ctrl-C policy for a followed run. The first
+// signal detaches from the run or asks Nextflow to shut down, the second one
+// deletes the run together with its worker pods. The returned function
+// uninstalls the policy.
+func handleInterrupts(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace, runName, policy string) func() {
+	sigs := make(chan os.Signal, 2)
+	stopped := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigs:
+		case <-stopped:
+			return
+		}
+		if policy != InterruptCancel {
+			fmt.Printf("\nDetached from run '%s', it keeps running in namespace '%s'.\n", runName, namespace)
+			fmt.Printf("Follow its output again with: nextflow-go attach %s -n %s\n", runName, namespace)
+			os.Exit(130)
+		}
+
+		fmt.Printf("\nCancelling run '%s', waiting for Nextflow to clean up (press Ctrl-C again to force)...\n", runName)
+		if err := signalHead(ctx, clientset, restConfig, namespace, runName); err != nil {
+			fmt.Printf("Unable to signal Nextflow: %v\n", err)
+			deleteRun(ctx, clientset, namespace, runName)
+			os.Exit(130)
+		}
+
+		<-sigs
+		fmt.Printf("\nForcing deletion of run '%s'...\n", runName)
+		deleteRun(ctx, clientset, namespace, runName)
+		os.Exit(130)
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(stopped)
+	}
+}
+
+// signalHead marks the run as cancelled and sends SIGTERM to Nextflow in the
+// running head pod of the run.
+func signalHead(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace, runName string) error {
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}}}`, annotationCancelled)
+	if _, err := clientset.BatchV1().Jobs(namespace).Patch(ctx, runName, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", runName),
+	})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		return execInPod(ctx, clientset, restConfig, namespace, pod.Name, runName,
+			[]string{"/bin/sh", "-c", "kill -TERM -1"}, nil, nil, os.Stderr)
+	}
+	return fmt.Errorf("no running head pod found for run '%s'", runName)
+}
+
+// deleteRun removes the head job, its pods and secret, and any pods or jobs
+// Nextflow started for the run. It returns the names of removed objects.
+func deleteRun(ctx context.Context, clientset kubernetes.Interface, namespace, runName string) []string {
+	var removed []string
+	propagation := metav1.DeletePropagationBackground
+	deleteOpts := metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	// The config secret goes with the job when the job owns it. Runs
+	// submitted with -keep-record keep theirs for resume.
+	secretName := ""
+	if job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, runName, metav1.GetOptions{}); err == nil {
+		if secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, configSecretName(job), metav1.GetOptions{}); err == nil && ownedBy(secret.OwnerReferences, job.UID) {
+			secretName = secret.Name
+		}
+	}
+
+	err := clientset.BatchV1().Jobs(namespace).Delete(ctx, runName, deleteOpts)
+	if err == nil {
+		removed = append(removed, "job/"+runName)
+		if secretName != "" {
+			removed = append(removed, "secret/"+secretName)
+		}
+	} else if !apierrors.IsNotFound(err) {
+		fmt.Printf("Error deleting job %s: %v\n", runName, err)
+	}
+
+	listOpts := metav1.ListOptions{LabelSelector: workerSelector(runName)}
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, listOpts)
+	if err != nil {
+		fmt.Printf("Error listing worker jobs: %v\n", err)
+	} else {
+		for _, job := range jobs.Items {
+			if err := clientset.BatchV1().Jobs(namespace).Delete(ctx, job.Name, deleteOpts); err != nil {
+				if !apierrors.IsNotFound(err) {
+					fmt.Printf("Error deleting job %s: %v\n", job.Name, err)
+				}
+				continue
+			}
+			removed = append(removed, "job/"+job.Name)
+		}
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, listOpts)
+	if err != nil {
+		fmt.Printf("Error listing worker pods: %v\n", err)
+	} else {
+		for _, pod := range pods.Items {
+			if err := clientset.CoreV1().Pods(namespace).Delete(ctx, pod.Name, deleteOpts); err != nil {
+				if !apierrors.IsNotFound(err) {
+					fmt.Printf("Error deleting pod %s: %v\n", pod.Name, err)
+				}
+				continue
+			}
+			removed = append(removed, "pod/"+pod.Name)
+		}
+	}
+
+	for _, name := range removed {
+		fmt.Printf("Deleted %s\n", name)
+	}
+	return removed
+}
+
+// ownedBy reports whether the owner references include the object uid.
+func ownedBy(refs []metav1.OwnerReference, uid types.UID) bool {
+	for _, ref := range refs {
+		if ref.UID == uid {
+			return true
+		}
+	}
+	return false
+}
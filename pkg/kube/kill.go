@@ -0,0 +1,71 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"nextflow-go/pkg/args"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Kill stops a run gracefully and removes everything that belongs to it.
+func Kill() {
+	args := args.ParseKillArgs()
+	namespace := resolveNamespace(args.Namespace, loadK8sConfig(args.ConfigName))
+
+	clientset, restConfig, err := newClient()
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+
+	// Without the head job, worker pods and jobs left behind by the run
+	// are still removed.
+	runName := args.RunName
+	job, err := findRun(ctx, clientset, namespace, runName)
+	if err != nil {
+		fmt.Printf("%v, removing what is left of it\n", err)
+	} else if err := signalHead(ctx, clientset, restConfig, namespace, job.Name); err != nil {
+		fmt.Printf("Not signalling Nextflow: %v\n", err)
+	} else {
+		fmt.Printf("Sent SIGTERM to Nextflow in run '%s', waiting up to %s for it to stop its task pods...\n", job.Name, args.Grace)
+		if !waitForHeadStop(ctx, clientset, namespace, job.Name, args.Grace) {
+			fmt.Printf("Nextflow did not stop within %s\n", args.Grace)
+		}
+	}
+
+	removed := deleteRun(ctx, clientset, namespace, runName)
+	if job == nil && len(removed) == 0 {
+		fmt.Printf("Nothing of run '%s' found in namespace '%s'.\n", runName, namespace)
+		os.Exit(1)
+	}
+	fmt.Printf("Run '%s' killed, %d objects removed.\n", runName, len(removed))
+}
+
+// waitForHeadStop waits until no head container of the job is running.
+func waitForHeadStop(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("job-name=%s", jobName),
+		})
+		if err == nil {
+			running := false
+			for _, pod := range pods.Items {
+				if pod.Status.Phase == corev1.PodRunning {
+					running = true
+				}
+			}
+			if !running {
+				return true
+			}
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"nextflow-go/pkg/args"
+	"nextflow-go/pkg/utils"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RunInfo is a summary of one launcher run as printed by `nextflow-go list`.
+type RunInfo struct {
+	Name          string    `json:"name"`
+	Namespace     string    `json:"namespace"`
+	Status        string    `json:"status"`
+	User          string    `json:"user,omitempty"`
+	StartTime     time.Time `json:"startTime"`
+	Duration      string    `json:"duration"`
+	HeadImage     string    `json:"headImage"`
+	Args          string    `json:"args"`
+	ActiveWorkers int       `json:"activeWorkers"`
+	// Removed is set for runs whose head job is gone and which are listed
+	// from their record.
+	Removed bool `json:"removed,omitempty"`
+}
+
+// List prints the launcher runs in the namespace or in all namespaces.
+func List() {
+	args := args.ParseListArgs()
+	namespace := metav1.NamespaceAll
+	if !args.AllNamespaces {
+		namespace = resolveNamespace(args.Namespace, loadK8sConfig(args.ConfigName))
+	}
+
+	clientset, _, err := newClient()
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+
+	selector := "app=nextflow"
+	if args.Selector != "" {
+		selector += "," + args.Selector
+	}
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		panic(err)
+	}
+
+	workers := map[string]int{}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: "nextflow.io/runName"})
+	if err != nil {
+		panic(err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning || pod.Status.Phase == corev1.PodPending {
+			workers[pod.Namespace+"/"+pod.Labels["nextflow.io/runName"]]++
+		}
+	}
+
+	// Runs kept with -keep-record are listed from their record once the
+	// head job is removed.
+	recorded, err := recordedRuns(ctx, clientset, namespace, selector)
+	if err != nil && !apierrors.IsForbidden(err) {
+		panic(err)
+	}
+	existing := map[string]bool{}
+	for _, job := range jobs.Items {
+		existing[job.Namespace+"/"+job.Name] = true
+	}
+
+	runs := []RunInfo{}
+	for i, job := range append(jobs.Items, recorded...) {
+		if i >= len(jobs.Items) && existing[job.Namespace+"/"+job.Name] {
+			continue
+		}
+		run := runInfo(&job)
+		if i >= len(jobs.Items) {
+			run.Removed = true
+			if run.Status == "Pending" || run.Status == "Running" {
+				// The launcher did not see the run finish.
+				run.Status, run.Duration = "Unknown", ""
+			}
+		}
+		run.ActiveWorkers = workers[job.Namespace+"/"+run.Name]
+		if args.Status != "" && !strings.EqualFold(args.Status, run.Status) {
+			continue
+		}
+		if args.User != "" && args.User != run.User {
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	if args.Output == "json" {
+		utils.PrintAsJSON(runs)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if args.AllNamespaces {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "NAME\tSTATUS\tUSER\tSTARTED\tDURATION\tWORKERS\tIMAGE\tARGS")
+	for _, run := range runs {
+		if args.AllNamespaces {
+			fmt.Fprintf(w, "%s\t", run.Namespace)
+		}
+		status := run.Status
+		if run.Removed {
+			status += " (removed)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", run.Name, status, run.User,
+			run.StartTime.Local().Format("2006-01-02 15:04"), run.Duration, run.ActiveWorkers, run.HeadImage, run.Args)
+	}
+	w.Flush()
+}
+
+func runInfo(job *batchv1.Job) RunInfo {
+	run := RunInfo{
+		Name:      job.Labels["runName"],
+		Namespace: job.Namespace,
+		Status:    runStatus(job),
+		User:      job.Annotations[annotationUser],
+		StartTime: job.CreationTimestamp.Time,
+		Args:      job.Annotations[annotationArgs],
+	}
+	if run.Name == "" {
+		run.Name = job.Name
+	}
+	if job.Status.StartTime != nil {
+		run.StartTime = job.Status.StartTime.Time
+	}
+	if containers := job.Spec.Template.Spec.Containers; len(containers) > 0 {
+		run.HeadImage = containers[0].Image
+	}
+
+	end := time.Now()
+	if job.Status.CompletionTime != nil {
+		end = job.Status.CompletionTime.Time
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			end = cond.LastTransitionTime.Time
+		}
+	}
+	run.Duration = end.Sub(run.StartTime).Round(time.Second).String()
+	return run
+}
+
+func runStatus(job *batchv1.Job) string {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return "Succeeded"
+		case batchv1.JobFailed:
+			return "Failed"
+		}
+	}
+	if job.Status.Ready != nil && *job.Status.Ready > 0 {
+		return "Running"
+	}
+	return "Pending"
+}
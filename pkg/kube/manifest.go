@@ -0,0 +1,90 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// redacted replaces the values of the config secret in dry-run output.
+const redacted = "<redacted>"
+
+// manifest collects the objects a dry run would create, so they can be
+// printed as one document that kubectl apply accepts.
+type manifest struct {
+	Namespace   string
+	ShowSecrets bool
+	objects     []runtime.Object
+}
+
+// add appends an object, setting its type and namespace.
+func (m *manifest) add(obj runtime.Object, apiVersion, kind string) {
+	obj.GetObjectKind().SetGroupVersionKind(schema.FromAPIVersionAndKind(apiVersion, kind))
+	if meta, ok := obj.(metav1.Object); ok {
+		meta.SetNamespace(m.Namespace)
+	}
+	if secret, ok := obj.(*corev1.Secret); ok && !m.ShowSecrets {
+		redactedSecret := secret.DeepCopy()
+		redactedSecret.StringData = make(map[string]string)
+		for key := range secret.Data {
+			redactedSecret.StringData[key] = redacted
+		}
+		redactedSecret.Data = nil
+		obj = redactedSecret
+	}
+	m.objects = append(m.objects, obj)
+}
+
+// print writes the objects as multi-document YAML or as a JSON List.
+func (m *manifest) print(w io.Writer, format string) error {
+	if format == "json" {
+		list := metav1.List{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}}
+		for _, obj := range m.objects {
+			raw, err := json.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			list.Items = append(list.Items, runtime.RawExtension{Raw: raw})
+		}
+		out, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	}
+
+	for _, obj := range m.objects {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactedKeys returns the keys of the secrets whose values were redacted.
+func (m *manifest) redactedKeys() []string {
+	var keys []string
+	for _, obj := range m.objects {
+		if secret, ok := obj.(*corev1.Secret); ok {
+			for key, value := range secret.StringData {
+				if value == redacted {
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
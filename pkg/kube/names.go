@@ -0,0 +1,76 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nextflow-go/pkg/args"
+	"nextflow-go/pkg/utils"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// candidateRunName returns the name given with -name, which has to be valid
+// as is, or a name generated from -name-template or at random.
+func candidateRunName(a args.Args) (string, error) {
+	switch {
+	case a.JobName != "":
+		if err := utils.ValidateRunName(a.JobName); err != nil {
+			return "", fmt.Errorf("%v, for example '%s'", err, utils.SanitizeRunName(a.JobName))
+		}
+		return a.JobName, nil
+	case a.NameTemplate != "":
+		return utils.ExpandNameTemplate(a.NameTemplate, utils.CurrentUser(), a.Pipeline(), time.Now())
+	default:
+		return utils.GenerateRandomName(), nil
+	}
+}
+
+// uniqueRunName returns the name, or when it is already used by a job, a
+// run record or an entry of the Nextflow history, the name with the first
+// free numbered suffix.
+func uniqueRunName(ctx context.Context, clientset kubernetes.Interface, namespace, name string, history [][]string) (string, error) {
+	inHistory := historyNames(history)
+	candidate := name
+	for n := 2; ; n++ {
+		used, err := runNameUsed(ctx, clientset, namespace, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !used && !inHistory[candidate] {
+			break
+		}
+		candidate = utils.RunNameWithSuffix(name, fmt.Sprintf("-%d", n))
+	}
+	if candidate != name {
+		fmt.Printf("Run name '%s' is already used, using '%s'\n", name, candidate)
+	}
+	return candidate, nil
+}
+
+// historyNames returns the run names in the entries of the Nextflow history.
+func historyNames(history [][]string) map[string]bool {
+	names := make(map[string]bool)
+	for _, fields := range history {
+		if len(fields) > 2 {
+			names[fields[2]] = true
+		}
+	}
+	return names
+}
+
+// runNameUsed reports whether a job or a run record with the name exists.
+func runNameUsed(ctx context.Context, clientset kubernetes.Interface, namespace, name string) (bool, error) {
+	_, err := clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		return err == nil, err
+	}
+	_, err = clientset.CoreV1().ConfigMaps(namespace).Get(ctx, recordName(name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,155 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"nextflow-go/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// preflightIssue is a problem found before submitting a run, with a
+// suggested fix.
+type preflightIssue struct {
+	Problem string
+	Fix     string
+}
+
+// preflight runs the checks before the config secret and the head job are
+// created. It reports all problems and exits unless there are none.
+func preflight(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace string, k8sConfig map[string]string, volumes []string, launchDir string, head corev1.PodSpec, keepRecord bool) {
+	serviceAccount := "default"
+	if k8sConfig["serviceAccount"] != "" {
+		serviceAccount = utils.Stripped(k8sConfig["serviceAccount"])
+	}
+
+	missing, issues := checkPermissions(ctx, clientset, restConfig, namespace, serviceAccount, keepRecord)
+	issues = append(issues, checkVolumes(ctx, clientset, namespace, volumes)...)
+	issues = append(issues, checkPaths(k8sConfig, volumes, launchDir)...)
+	issues = append(issues, checkQuota(ctx, clientset, namespace, head)...)
+	if len(issues) == 0 {
+		return
+	}
+
+	if len(missing) > 0 {
+		printPermissions(missing)
+	}
+	fmt.Printf("Preflight checks failed:\n")
+	for _, issue := range issues {
+		fmt.Printf("  - %s\n", issue.Problem)
+		if issue.Fix != "" {
+			fmt.Printf("    fix: %s\n", issue.Fix)
+		}
+	}
+	fmt.Printf("Fix the problems above or pass -skip-preflight to submit anyway.\n")
+	os.Exit(1)
+}
+
+// checkVolumes checks that the PVCs of the run exist, are bound and can be
+// shared by the head and the worker pods.
+func checkVolumes(ctx context.Context, clientset kubernetes.Interface, namespace string, volumes []string) []preflightIssue {
+	var issues []preflightIssue
+	for _, spec := range volumes {
+		vol, err := utils.ParseVolume(spec)
+		if err != nil || vol.Kind != utils.VolumePVC {
+			continue
+		}
+		pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, vol.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			issues = append(issues, preflightIssue{
+				Problem: fmt.Sprintf("PVC %s does not exist in namespace %s", vol.Name, namespace),
+				Fix:     fmt.Sprintf("create it, or pass -v new:%s:<size>:%s or -create-missing-pvcs", vol.Name, vol.MountPath),
+			})
+			continue
+		}
+		if err != nil {
+			issues = append(issues, preflightIssue{Problem: fmt.Sprintf("unable to read PVC %s: %v", vol.Name, err)})
+			continue
+		}
+
+		modes := pvc.Spec.AccessModes
+		switch pvc.Status.Phase {
+		case corev1.ClaimBound:
+			modes = pvc.Status.AccessModes
+		case corev1.ClaimPending:
+			class, err := storageClass(ctx, clientset, stringValue(pvc.Spec.StorageClassName))
+			if err != nil || class == nil || class.VolumeBindingMode == nil || *class.VolumeBindingMode != storagev1.VolumeBindingWaitForFirstConsumer {
+				issues = append(issues, preflightIssue{
+					Problem: fmt.Sprintf("PVC %s is Pending", vol.Name),
+					Fix:     fmt.Sprintf("check the events of `kubectl describe pvc %s -n %s`", vol.Name, namespace),
+				})
+			}
+		default:
+			issues = append(issues, preflightIssue{
+				Problem: fmt.Sprintf("PVC %s is %s", vol.Name, pvc.Status.Phase),
+				Fix:     "recreate the PVC, its volume is no longer available",
+			})
+			continue
+		}
+
+		shared := false
+		for _, mode := range modes {
+			if mode == corev1.ReadWriteMany || (vol.ReadOnly && mode == corev1.ReadOnlyMany) {
+				shared = true
+			}
+		}
+		if !shared {
+			issues = append(issues, preflightIssue{
+				Problem: fmt.Sprintf("PVC %s has access modes %v, the worker pods cannot share it", vol.Name, modes),
+				Fix:     "use a PVC with ReadWriteMany access on shared storage (e.g. NFS, CephFS)",
+			})
+		}
+	}
+	return issues
+}
+
+// checkPaths checks that the launch, work and project directories are on
+// writable shared volumes, so that the head and the worker pods see them.
+func checkPaths(k8sConfig map[string]string, volumes []string, launchDir string) []preflightIssue {
+	var issues []preflightIssue
+	podVolumes, mounts, err := utils.BuildVolumes(volumes)
+	if err != nil {
+		return []preflightIssue{{Problem: err.Error()}}
+	}
+	var mountPaths []string
+	for _, mount := range mounts {
+		if onVolume(mount.MountPath, podVolumes, mounts, true) {
+			mountPaths = append(mountPaths, mount.MountPath)
+		}
+	}
+
+	dirs := []struct{ key, dir string }{
+		{"launchDir", launchDir},
+		{"workDir", utils.Stripped(k8sConfig["workDir"])},
+		{"projectDir", utils.Stripped(k8sConfig["projectDir"])},
+	}
+	for _, d := range dirs {
+		if d.dir == "" || onVolume(d.dir, podVolumes, mounts, true) {
+			continue
+		}
+		fix := fmt.Sprintf("set k8s.%s to a path under one of the writable volumes %s", d.key, strings.Join(mountPaths, ", "))
+		if len(mountPaths) == 0 {
+			fix = "mount a ReadWriteMany PVC with -v pvc:dir and place it there"
+		}
+		issues = append(issues, preflightIssue{
+			Problem: fmt.Sprintf("%s %s is not on a writable shared volume", d.key, d.dir),
+			Fix:     fix,
+		})
+	}
+	return issues
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
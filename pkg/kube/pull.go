@@ -0,0 +1,263 @@
+package kube
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"nextflow-go/pkg/args"
+	"nextflow-go/pkg/utils"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// remoteFile is a file below the pulled directory as listed by the helper pod.
+type remoteFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Pull copies a directory of a run, by default its --outdir, from the
+// volumes to the local machine.
+func Pull() {
+	image := args.DefaultHeadImage
+	args := args.ParsePullArgs()
+	k8sConfig := loadK8sConfig(args.ConfigName)
+	namespace := resolveNamespace(args.Namespace, k8sConfig)
+
+	clientset, restConfig, err := newClient()
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+
+	launchDir := utils.Stripped(k8sConfig["launchDir"])
+	remoteDir := args.Path
+	var volumes []string
+	user := runAsUser(k8sConfig)
+	if job, err := findRun(ctx, clientset, namespace, args.RunName); err == nil {
+		image = job.Spec.Template.Spec.Containers[0].Image
+		if dir := job.Annotations[annotationLaunchDir]; dir != "" {
+			launchDir = dir
+		}
+		if remoteDir == "" {
+			remoteDir = outdirParam(strings.Fields(job.Annotations[annotationArgs]))
+		}
+		// Mount the volumes the run was submitted with, -v adds to them.
+		if spec, err := recordedSpec(job); err == nil {
+			if volumes, err = utils.ExtendVolumes(spec.Volumes, args.Volumes); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			user = runAsUser(spec.K8sConfig)
+		}
+	}
+	if volumes == nil {
+		if volumes, err = utils.NormalizeVolumes(args.Volumes, k8sConfig); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if remoteDir == "" {
+		fmt.Printf("The output directory of run '%s' is unknown, pass it as: nextflow-go pull %s <path>\n", args.RunName, args.RunName)
+		os.Exit(1)
+	}
+	if !path.IsAbs(remoteDir) {
+		remoteDir = path.Join(launchDir, remoteDir)
+	}
+	dest := args.Dest
+	if dest == "" {
+		dest = path.Base(remoteDir)
+	}
+
+	podVolumes, mounts, err := utils.BuildVolumes(volumes)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !onVolume(remoteDir, podVolumes, mounts, false) {
+		fmt.Printf("%s is not on any of the mounted volumes %v\n", remoteDir, volumes)
+		os.Exit(1)
+	}
+
+	helper, err := startHelperPod(ctx, clientset, namespace, helperSpec{
+		RunName: args.RunName, Purpose: "pull", Image: image, RunAsUser: user, Volumes: podVolumes, Mounts: mounts,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer deleteHelperPod(clientset, namespace, helper.Name)
+
+	if err := pullDir(ctx, clientset, restConfig, namespace, helper.Name, remoteDir, dest, args.Include, args.Exclude); err != nil {
+		fmt.Printf("Error pulling %s: %v\n", remoteDir, err)
+		deleteHelperPod(clientset, namespace, helper.Name)
+		os.Exit(1)
+	}
+}
+
+// pullDir copies remoteDir into dest. Files that are present locally with the
+// same size and modification time are skipped, and files left partially
+// written by an interrupted pull are resumed.
+func pullDir(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace, pod, remoteDir, dest string, include, exclude []string) error {
+	dir := utils.ShellQuote(remoteDir)
+	var listing bytes.Buffer
+	err := execInPod(ctx, clientset, restConfig, namespace, pod, "helper",
+		[]string{"/bin/sh", "-c", fmt.Sprintf("cd %s && find . -type f -exec stat -c '%%s %%Y %%n' {} +", dir)},
+		nil, &listing, os.Stderr)
+	if err != nil {
+		return err
+	}
+	files, err := parseListing(&listing)
+	if err != nil {
+		return err
+	}
+
+	var transfer, resume []remoteFile
+	skipped := 0
+	for _, file := range files {
+		if !selected(file.Name, include, exclude) {
+			continue
+		}
+		local := filepath.Join(dest, filepath.FromSlash(file.Name))
+		if info, err := os.Stat(local); err == nil && info.Size() == file.Size && info.ModTime().Unix() == file.ModTime.Unix() {
+			skipped++
+			continue
+		}
+		if info, err := os.Stat(local + utils.PartialSuffix); err == nil && info.Size() > 0 && info.Size() < file.Size {
+			resume = append(resume, file)
+			continue
+		}
+		transfer = append(transfer, file)
+	}
+
+	for _, file := range resume {
+		if err := resumeFile(ctx, clientset, restConfig, namespace, pod, remoteDir, dest, file); err != nil {
+			return err
+		}
+		fmt.Printf("  %s (resumed)\n", file.Name)
+	}
+
+	if len(transfer) > 0 {
+		var list bytes.Buffer
+		for _, file := range transfer {
+			list.WriteString(file.Name + "\n")
+		}
+		reader, writer := io.Pipe()
+		extracted := make(chan []string)
+		go func() {
+			files, err := utils.ExtractTar(reader, dest)
+			reader.CloseWithError(err)
+			extracted <- files
+		}()
+		err = execInPod(ctx, clientset, restConfig, namespace, pod, "helper",
+			[]string{"/bin/sh", "-c", fmt.Sprintf("cd %s && tar cf - -T -", dir)}, &list, writer, os.Stderr)
+		writer.CloseWithError(err)
+		for _, file := range <-extracted {
+			fmt.Printf("  %s\n", file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Pulled %d files into %s (%d resumed, %d unchanged files skipped).\n", len(transfer)+len(resume), dest, len(resume), skipped)
+	return nil
+}
+
+// resumeFile appends the missing tail of a partially pulled file.
+func resumeFile(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace, pod, remoteDir, dest string, file remoteFile) error {
+	local := filepath.Join(dest, filepath.FromSlash(file.Name))
+	partial := local + utils.PartialSuffix
+	info, err := os.Stat(partial)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf("tail -c +%d %s", info.Size()+1, utils.ShellQuote(path.Join(remoteDir, file.Name)))
+	err = execInPod(ctx, clientset, restConfig, namespace, pod, "helper", []string{"/bin/sh", "-c", script}, nil, f, os.Stderr)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(partial); err != nil || info.Size() != file.Size {
+		return fmt.Errorf("resumed %s does not have the expected size %d", file.Name, file.Size)
+	}
+	if err := os.Rename(partial, local); err != nil {
+		return err
+	}
+	return os.Chtimes(local, file.ModTime, file.ModTime)
+}
+
+// parseListing reads `stat -c '%s %Y %n'` lines.
+func parseListing(r io.Reader) ([]remoteFile, error) {
+	var files []remoteFile
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected listing line %q", scanner.Text())
+		}
+		mtime, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected listing line %q", scanner.Text())
+		}
+		files = append(files, remoteFile{
+			Name:    strings.TrimPrefix(fields[2], "./"),
+			Size:    size,
+			ModTime: time.Unix(mtime, 0),
+		})
+	}
+	return files, scanner.Err()
+}
+
+// selected applies the include and exclude globs to a relative file name.
+// A glob matches either the whole relative path or the base name.
+func selected(name string, include, exclude []string) bool {
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if len(include) > 0 && !matches(include) {
+		return false
+	}
+	return !matches(exclude)
+}
+
+// outdirParam returns the value of --outdir among the nextflow arguments.
+func outdirParam(nextflowArgs []string) string {
+	for i, arg := range nextflowArgs {
+		if value, ok := strings.CutPrefix(arg, "--outdir="); ok {
+			return value
+		}
+		if arg == "--outdir" && i+1 < len(nextflowArgs) {
+			return nextflowArgs[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,277 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// headFlags are the launcher options that set a resource of the head pod.
+var headFlags = map[corev1.ResourceName]string{
+	corev1.ResourceCPU:              "-head-cpu-request/-head-cpu-limit",
+	corev1.ResourceMemory:           "-head-memory-request/-head-memory-limit",
+	corev1.ResourceEphemeralStorage: "-head-ephemeral-storage",
+}
+
+// headQuotaUsage returns how much of each quota resource the head pod and
+// its job and secret use.
+func headQuotaUsage(resources corev1.ResourceRequirements) map[corev1.ResourceName]resource.Quantity {
+	one := resource.MustParse("1")
+	usage := map[corev1.ResourceName]resource.Quantity{
+		corev1.ResourcePods:    one,
+		"count/pods":           one,
+		"count/jobs.batch":     one,
+		corev1.ResourceSecrets: one,
+		"count/secrets":        one,
+	}
+	for name, quantity := range resources.Requests {
+		usage[name] = quantity
+		usage[corev1.ResourceName("requests."+string(name))] = quantity
+	}
+	for name, quantity := range resources.Limits {
+		usage[corev1.ResourceName("limits."+string(name))] = quantity
+	}
+	return usage
+}
+
+// checkQuota checks that the head pod fits into the remaining ResourceQuota
+// of the namespace and satisfies its LimitRanges, and reports how much of
+// the quota the worker pods will have left. Quotas whose scopes do not
+// select the head pod are skipped.
+func checkQuota(ctx context.Context, clientset kubernetes.Interface, namespace string, head corev1.PodSpec) []preflightIssue {
+	var issues []preflightIssue
+	resources := head.Containers[0].Resources
+
+	limitRanges, err := clientset.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil && !apierrors.IsForbidden(err) {
+		issues = append(issues, preflightIssue{Problem: fmt.Sprintf("unable to read the LimitRanges of namespace %s: %v", namespace, err)})
+	}
+	defaults := make(map[corev1.ResourceName]bool)
+	if err == nil {
+		for _, lr := range limitRanges.Items {
+			for _, item := range lr.Spec.Limits {
+				if item.Type != corev1.LimitTypeContainer && item.Type != corev1.LimitTypePod {
+					continue
+				}
+				for name := range item.Default {
+					defaults[name] = true
+				}
+				issues = append(issues, checkLimitRange(lr.Name, item, resources)...)
+			}
+		}
+	}
+
+	quotas, err := clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if !apierrors.IsForbidden(err) {
+			issues = append(issues, preflightIssue{Problem: fmt.Sprintf("unable to read the ResourceQuotas of namespace %s: %v", namespace, err)})
+		}
+		return issues
+	}
+	usage := headQuotaUsage(resources)
+	bestEffort := headBestEffort(resources, defaults)
+	for _, quota := range quotas.Items {
+		if !quotaSelects(quota.Spec, head, bestEffort) {
+			continue
+		}
+		hardLimits := quota.Status.Hard
+		if len(hardLimits) == 0 {
+			hardLimits = quota.Spec.Hard
+		}
+		var names []string
+		for name := range hardLimits {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+
+		var left []string
+		for _, key := range names {
+			name := corev1.ResourceName(key)
+			hard := hardLimits[name]
+			need, ok := usage[name]
+			if !ok {
+				resourceName := corev1.ResourceName(key[strings.Index(key, ".")+1:])
+				if (strings.HasPrefix(key, "limits.") || strings.HasPrefix(key, "requests.")) && headFlags[resourceName] != "" && !defaults[resourceName] {
+					issues = append(issues, preflightIssue{
+						Problem: fmt.Sprintf("ResourceQuota %s tracks %s, but the head pod does not set it", quota.Name, key),
+						Fix:     fmt.Sprintf("set %s of the head pod", key),
+					})
+				}
+				continue
+			}
+			remaining := hard.DeepCopy()
+			remaining.Sub(quota.Status.Used[name])
+			if need.Cmp(remaining) > 0 {
+				issue := preflightIssue{
+					Problem: fmt.Sprintf("ResourceQuota %s: the head pod needs %s of %s, only %s of %s are left", quota.Name, need.String(), key, remaining.String(), hard.String()),
+					Fix:     "wait for other runs to finish or ask for a larger quota",
+				}
+				resourceName := corev1.ResourceName(key[strings.Index(key, ".")+1:])
+				if flag := headFlags[resourceName]; flag != "" {
+					issue.Fix = fmt.Sprintf("lower %s, wait for other runs to finish or ask for a larger quota", flag)
+				}
+				issues = append(issues, issue)
+				continue
+			}
+			remaining.Sub(need)
+			if name != corev1.ResourcePods && !strings.HasPrefix(key, "count/") && name != corev1.ResourceSecrets {
+				left = append(left, fmt.Sprintf("%s %s", key, remaining.String()))
+			}
+		}
+		if len(left) > 0 {
+			fmt.Printf("ResourceQuota %s leaves %s for the worker pods after the head pod\n", quota.Name, strings.Join(left, ", "))
+		}
+	}
+	return issues
+}
+
+// headBestEffort reports whether the head pod has the BestEffort QoS class:
+// it sets no CPU or memory requests or limits, and no LimitRange adds them.
+func headBestEffort(resources corev1.ResourceRequirements, defaults map[corev1.ResourceName]bool) bool {
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		_, request := resources.Requests[name]
+		_, limit := resources.Limits[name]
+		if request || limit || defaults[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// quotaSelects reports whether the scopes and the scope selector of a
+// ResourceQuota select the head pod, as the quota admission does.
+func quotaSelects(spec corev1.ResourceQuotaSpec, head corev1.PodSpec, bestEffort bool) bool {
+	for _, scope := range spec.Scopes {
+		if !scopeSelects(corev1.ScopedResourceSelectorRequirement{ScopeName: scope, Operator: corev1.ScopeSelectorOpExists}, head, bestEffort) {
+			return false
+		}
+	}
+	if spec.ScopeSelector != nil {
+		for _, req := range spec.ScopeSelector.MatchExpressions {
+			if !scopeSelects(req, head, bestEffort) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func scopeSelects(req corev1.ScopedResourceSelectorRequirement, head corev1.PodSpec, bestEffort bool) bool {
+	switch req.ScopeName {
+	case corev1.ResourceQuotaScopeTerminating:
+		return head.ActiveDeadlineSeconds != nil
+	case corev1.ResourceQuotaScopeNotTerminating:
+		return head.ActiveDeadlineSeconds == nil
+	case corev1.ResourceQuotaScopeBestEffort:
+		return bestEffort
+	case corev1.ResourceQuotaScopeNotBestEffort:
+		return !bestEffort
+	case corev1.ResourceQuotaScopePriorityClass:
+		switch req.Operator {
+		case corev1.ScopeSelectorOpExists:
+			return head.PriorityClassName != ""
+		case corev1.ScopeSelectorOpDoesNotExist:
+			return head.PriorityClassName == ""
+		case corev1.ScopeSelectorOpIn:
+			return slices.Contains(req.Values, head.PriorityClassName)
+		case corev1.ScopeSelectorOpNotIn:
+			return !slices.Contains(req.Values, head.PriorityClassName)
+		}
+	case corev1.ResourceQuotaScopeCrossNamespacePodAffinity:
+		return crossNamespaceAffinity(head.Affinity)
+	}
+	return true
+}
+
+// crossNamespaceAffinity reports whether a pod (anti-)affinity term of the
+// affinity selects pods in other namespaces.
+func crossNamespaceAffinity(affinity *corev1.Affinity) bool {
+	if affinity == nil {
+		return false
+	}
+	var terms []corev1.PodAffinityTerm
+	if pa := affinity.PodAffinity; pa != nil {
+		terms = append(terms, pa.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, weighted := range pa.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, weighted.PodAffinityTerm)
+		}
+	}
+	if paa := affinity.PodAntiAffinity; paa != nil {
+		terms = append(terms, paa.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, weighted := range paa.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, weighted.PodAffinityTerm)
+		}
+	}
+	for _, term := range terms {
+		if len(term.Namespaces) > 0 || term.NamespaceSelector != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLimitRange checks the head container against the min, max and
+// limit/request ratio constraints of a LimitRange item.
+func checkLimitRange(name string, item corev1.LimitRangeItem, resources corev1.ResourceRequirements) []preflightIssue {
+	var issues []preflightIssue
+	fix := func(resourceName corev1.ResourceName) string {
+		if flag := headFlags[resourceName]; flag != "" {
+			return fmt.Sprintf("adjust %s of the head pod", flag)
+		}
+		return ""
+	}
+
+	for resourceName, min := range item.Min {
+		request, ok := resources.Requests[resourceName]
+		if !ok {
+			request, ok = resources.Limits[resourceName]
+		}
+		if ok && request.Cmp(min) < 0 {
+			issues = append(issues, preflightIssue{
+				Problem: fmt.Sprintf("LimitRange %s: %s request %s of the head pod is below the minimum %s", name, resourceName, request.String(), min.String()),
+				Fix:     fix(resourceName),
+			})
+		}
+	}
+	for resourceName, max := range item.Max {
+		limit, ok := resources.Limits[resourceName]
+		if !ok {
+			if _, hasDefault := item.Default[resourceName]; !hasDefault {
+				issues = append(issues, preflightIssue{
+					Problem: fmt.Sprintf("LimitRange %s requires a %s limit of at most %s, the head pod has none", name, resourceName, max.String()),
+					Fix:     fix(resourceName),
+				})
+			}
+			continue
+		}
+		if limit.Cmp(max) > 0 {
+			issues = append(issues, preflightIssue{
+				Problem: fmt.Sprintf("LimitRange %s: %s limit %s of the head pod exceeds the maximum %s", name, resourceName, limit.String(), max.String()),
+				Fix:     fix(resourceName),
+			})
+		}
+	}
+	for resourceName, maxRatio := range item.MaxLimitRequestRatio {
+		limit, hasLimit := resources.Limits[resourceName]
+		request, hasRequest := resources.Requests[resourceName]
+		if !hasLimit || !hasRequest || request.IsZero() {
+			continue
+		}
+		ratio := float64(limit.MilliValue()) / float64(request.MilliValue())
+		if ratio > maxRatio.AsApproximateFloat64() {
+			issues = append(issues, preflightIssue{
+				Problem: fmt.Sprintf("LimitRange %s: %s limit/request ratio %.2f of the head pod (%s/%s) exceeds %s", name, resourceName, ratio, limit.String(), request.String(), maxRatio.String()),
+				Fix:     fix(resourceName),
+			})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,175 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// permission is an action on a resource of the namespace.
+type permission struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+}
+
+func (p permission) resource() string {
+	resource := p.Resource
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	return resource
+}
+
+// launcherPermissions are needed by the user running the launcher: to pick a
+// free run name, to create the config secret and the head job, to follow and
+// wait for the head pod, to cancel the run, and to run and remove the helper pods that stage the
+// pipeline and read the Nextflow history.
+var launcherPermissions = []permission{
+	{Verb: "create", Resource: "secrets"},
+	{Verb: "get", Resource: "secrets"},
+	{Verb: "update", Resource: "secrets"},
+	{Verb: "get", Resource: "configmaps"},
+	{Verb: "create", Group: "batch", Resource: "jobs"},
+	{Verb: "get", Group: "batch", Resource: "jobs"},
+	{Verb: "watch", Group: "batch", Resource: "jobs"},
+	{Verb: "patch", Group: "batch", Resource: "jobs"},
+	{Verb: "create", Resource: "pods"},
+	{Verb: "get", Resource: "pods"},
+	{Verb: "list", Resource: "pods"},
+	{Verb: "watch", Resource: "pods"},
+	{Verb: "delete", Resource: "pods"},
+	{Verb: "get", Resource: "pods", Subresource: "log"},
+	{Verb: "create", Resource: "pods", Subresource: "exec"},
+	{Verb: "list", Resource: "events"},
+}
+
+// recordPermissions are needed in addition by runs with -keep-record, whose
+// summary ConfigMap is created at submit time and updated when they finish.
+var recordPermissions = []permission{
+	{Verb: "create", Resource: "configmaps"},
+	{Verb: "update", Resource: "configmaps"},
+}
+
+// executorPermissions are needed by the service account of the head pod for
+// the Nextflow k8s executor.
+var executorPermissions = []permission{
+	{Verb: "create", Resource: "pods"},
+	{Verb: "get", Resource: "pods"},
+	{Verb: "list", Resource: "pods"},
+	{Verb: "watch", Resource: "pods"},
+	{Verb: "delete", Resource: "pods"},
+	{Verb: "get", Resource: "pods", Subresource: "status"},
+	{Verb: "get", Resource: "pods", Subresource: "log"},
+	{Verb: "create", Group: "batch", Resource: "jobs"},
+	{Verb: "get", Group: "batch", Resource: "jobs"},
+	{Verb: "list", Group: "batch", Resource: "jobs"},
+	{Verb: "watch", Group: "batch", Resource: "jobs"},
+	{Verb: "delete", Group: "batch", Resource: "jobs"},
+	{Verb: "get", Resource: "persistentvolumeclaims"},
+}
+
+// missingPermission is a permission a subject lacks.
+type missingPermission struct {
+	Subject string
+	permission
+}
+
+// checkPermissions checks the permissions of the current user and, when the
+// user may impersonate it, of the service account of the head pod.
+func checkPermissions(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace, serviceAccount string, keepRecord bool) ([]missingPermission, []preflightIssue) {
+	var missing []missingPermission
+	var issues []preflightIssue
+
+	permissions := launcherPermissions
+	if keepRecord {
+		permissions = append(permissions[:len(permissions):len(permissions)], recordPermissions...)
+	}
+	denied, err := deniedPermissions(ctx, clientset, namespace, permissions)
+	if err != nil {
+		return nil, []preflightIssue{{Problem: fmt.Sprintf("unable to check the permissions of the current user: %v", err)}}
+	}
+	for _, p := range denied {
+		missing = append(missing, missingPermission{Subject: "current user", permission: p})
+	}
+	if len(denied) > 0 {
+		issues = append(issues, preflightIssue{
+			Problem: fmt.Sprintf("the current user lacks %d permissions in namespace %s", len(denied), namespace),
+			Fix:     "ask the cluster administrator for a role granting the permissions listed above",
+		})
+	}
+
+	impersonate := permission{Verb: "impersonate", Resource: "serviceaccounts"}
+	if denied, err := deniedPermissions(ctx, clientset, namespace, []permission{impersonate}); err != nil || len(denied) > 0 {
+		fmt.Printf("Note: the permissions of service account %s were not checked, the current user cannot impersonate it\n", serviceAccount)
+		return missing, issues
+	}
+	impersonated := rest.CopyConfig(restConfig)
+	impersonated.Impersonate = rest.ImpersonationConfig{UserName: fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount)}
+	saClient, err := kubernetes.NewForConfig(impersonated)
+	if err != nil {
+		return missing, append(issues, preflightIssue{Problem: fmt.Sprintf("unable to impersonate service account %s: %v", serviceAccount, err)})
+	}
+	denied, err = deniedPermissions(ctx, saClient, namespace, executorPermissions)
+	if err != nil {
+		return missing, append(issues, preflightIssue{Problem: fmt.Sprintf("unable to check the permissions of service account %s: %v", serviceAccount, err)})
+	}
+	for _, p := range denied {
+		missing = append(missing, missingPermission{Subject: "serviceaccount " + serviceAccount, permission: p})
+	}
+	if len(denied) > 0 {
+		issues = append(issues, preflightIssue{
+			Problem: fmt.Sprintf("service account %s lacks %d permissions the Nextflow k8s executor needs", serviceAccount, len(denied)),
+			Fix:     fmt.Sprintf("bind a role with the permissions listed above: kubectl create rolebinding nextflow --role=<role> --serviceaccount=%s:%s -n %s, or set k8s.serviceAccount", namespace, serviceAccount, namespace),
+		})
+	}
+	return missing, issues
+}
+
+// deniedPermissions returns the permissions that the subject of the client
+// is not allowed.
+func deniedPermissions(ctx context.Context, clientset kubernetes.Interface, namespace string, permissions []permission) ([]permission, error) {
+	var denied []permission
+	for _, p := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+				},
+			},
+		}
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if !result.Status.Allowed {
+			denied = append(denied, p)
+		}
+	}
+	return denied, nil
+}
+
+// printPermissions prints the missing permissions as a table.
+func printPermissions(missing []missingPermission) {
+	fmt.Printf("Missing permissions:\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  SUBJECT\tVERB\tRESOURCE")
+	for _, m := range missing {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", m.Subject, m.Verb, m.resource())
+	}
+	w.Flush()
+}
@@ -0,0 +1,140 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// recordApp labels the config secret and the summary ConfigMap of runs
+// submitted with -keep-record, which outlive the head job.
+const recordApp = "nextflow-go-record"
+
+// Keys of the summary ConfigMap of a run.
+const (
+	recordJob      = "job"
+	recordExitCode = "exitCode"
+)
+
+func recordName(runName string) string {
+	return "nf-run-" + runName
+}
+
+func recordLabels(runName string) map[string]string {
+	return map[string]string{"app": recordApp, "runName": runName}
+}
+
+// runRecord returns the summary ConfigMap of the run, holding the head job
+// as submitted.
+func runRecord(job *batchv1.Job) (*corev1.ConfigMap, error) {
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   recordName(job.Labels["runName"]),
+			Labels: recordLabels(job.Labels["runName"]),
+		},
+		Data: map[string]string{recordJob: string(encoded)},
+	}, nil
+}
+
+// finishRecord stores the finished head job and the exit code of the run in
+// its summary ConfigMap.
+func finishRecord(ctx context.Context, clientset kubernetes.Interface, namespace, runName string, exitCode int) error {
+	record, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, recordName(runName), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, runName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	record.Data[recordJob] = string(encoded)
+	record.Data[recordExitCode] = strconv.Itoa(exitCode)
+	_, err = clientset.CoreV1().ConfigMaps(namespace).Update(ctx, record, metav1.UpdateOptions{})
+	return err
+}
+
+// recordExit stores the exit code in the record of the run, if it was
+// submitted with -keep-record.
+func recordExit(ctx context.Context, clientset kubernetes.Interface, job *batchv1.Job, exitCode int) {
+	if spec, err := recordedSpec(job); err != nil || !spec.Args.KeepRecord {
+		return
+	}
+	if err := finishRecord(ctx, clientset, job.Namespace, job.Name, exitCode); err != nil {
+		fmt.Printf("Unable to update the record of run '%s': %v\n", job.Name, err)
+	}
+}
+
+// recordedExitCode returns the exit code stored in the record of the run,
+// if the record exists and the run was seen to finish.
+func recordedExitCode(ctx context.Context, clientset kubernetes.Interface, namespace, runName string) (int, bool) {
+	record, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, recordName(runName), metav1.GetOptions{})
+	if err != nil {
+		return 0, false
+	}
+	exitCode, err := strconv.Atoi(record.Data[recordExitCode])
+	return exitCode, err == nil
+}
+
+// recordedJob decodes the head job stored in a summary ConfigMap.
+func recordedJob(record *corev1.ConfigMap) (*batchv1.Job, error) {
+	job := &batchv1.Job{}
+	if err := json.Unmarshal([]byte(record.Data[recordJob]), job); err != nil {
+		return nil, fmt.Errorf("invalid record %s: %v", record.Name, err)
+	}
+	job.Namespace = record.Namespace
+	return job, nil
+}
+
+// recordedRuns returns the head jobs of the kept runs whose labels match the
+// selector. Records that cannot be decoded are skipped.
+func recordedRuns(ctx context.Context, clientset kubernetes.Interface, namespace, selector string) ([]batchv1.Job, error) {
+	match, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+	records, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + recordApp})
+	if err != nil {
+		return nil, err
+	}
+	var jobs []batchv1.Job
+	for i := range records.Items {
+		job, err := recordedJob(&records.Items[i])
+		if err != nil || !match.Matches(labels.Set(job.Labels)) {
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
+
+// findRecordedRun looks up the head job of the run, falling back to its
+// record when the job was already removed.
+func findRecordedRun(ctx context.Context, clientset kubernetes.Interface, namespace, runName string) (*batchv1.Job, error) {
+	job, err := findRun(ctx, clientset, namespace, runName)
+	if err == nil {
+		return job, nil
+	}
+	record, recordErr := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, recordName(runName), metav1.GetOptions{})
+	if apierrors.IsNotFound(recordErr) {
+		return nil, err
+	} else if recordErr != nil {
+		return nil, recordErr
+	}
+	return recordedJob(record)
+}
@@ -0,0 +1,223 @@
+package kube
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"nextflow-go/pkg/args"
+	"nextflow-go/pkg/config"
+	"nextflow-go/pkg/utils"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// launchSpec is the full specification of a submitted run. It is recorded
+// on the head job so the run can be resubmitted with -resume.
+type launchSpec struct {
+	Args        args.Args         `json:"args"`
+	K8sConfig   map[string]string `json:"k8sConfig"`
+	ConfigHash  string            `json:"configHash"`
+	Volumes     []string          `json:"volumes"`
+	LaunchDir   string            `json:"launchDir"`
+	Affinity    *corev1.Affinity  `json:"affinity,omitempty"`
+	PodTemplate string            `json:"podTemplate,omitempty"`
+	// ResumeOf is the name of the first run of a chain of resumed runs.
+	ResumeOf string `json:"resumeOf,omitempty"`
+	Session  string `json:"session,omitempty"`
+}
+
+// launchPlan is a launchSpec together with the namespace and the content of
+// the config secret.
+type launchPlan struct {
+	launchSpec
+	Namespace string
+	Data      map[string][]byte
+}
+
+// expiredRunHint explains why a finished run can no longer be resumed: its
+// head job, with the launch spec and the config secret it owns, is removed
+// when the -ttl expires.
+const expiredRunHint = "Runs can only be resumed until their head job is removed, launch with -keep-record or a longer -ttl to resume them later."
+
+// Resume resubmits a recorded run with -resume under a new derived name.
+func Resume() {
+	args := args.ParseResumeArgs()
+	namespace := resolveNamespace(args.Namespace, loadK8sConfig(args.ConfigName))
+
+	clientset, restConfig, err := newClient()
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+
+	job, err := findRecordedRun(ctx, clientset, namespace, args.RunName)
+	if err != nil {
+		fmt.Printf("%v\n%s\n", err, expiredRunHint)
+		os.Exit(1)
+	}
+	plan, err := resumePlan(ctx, clientset, restConfig, job, args.Overrides)
+	if err != nil {
+		fmt.Printf("Unable to resume run '%s': %v\n", job.Name, err)
+		os.Exit(1)
+	}
+	submit(ctx, clientset, restConfig, plan, nil)
+}
+
+// resumePlan rebuilds the launch plan of the job under a new name, with the
+// overrides applied and -resume pointing at the session of the job.
+func resumePlan(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, job *batchv1.Job, overrides []string) (*launchPlan, error) {
+	spec, err := recordedSpec(job)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := clientset.CoreV1().Secrets(job.Namespace).Get(ctx, configSecretName(job), metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("config of the run is no longer available: %v\n%s", err, expiredRunHint)
+	}
+
+	resumed, err := args.Override(spec.Args, overrides)
+	if err != nil {
+		return nil, err
+	}
+	data := secret.Data
+	for _, file := range []string{resumed.CustomFile, resumed.ParamsFile} {
+		if file == "" || file == spec.Args.CustomFile || file == spec.Args.ParamsFile {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		data[filepath.Base(file)] = content
+	}
+
+	if resumed.HeadPodTemplate != spec.Args.HeadPodTemplate {
+		if spec.PodTemplate, err = loadPodTemplate(resumed.HeadPodTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	if local := loadK8sConfig(spec.Args.ConfigName); len(local) > 0 && config.Hash(local) != spec.ConfigHash {
+		fmt.Printf("Warning: the k8s scope of %s changed since run '%s' was submitted, resuming with the recorded configuration\n", spec.Args.ConfigName, job.Name)
+	}
+
+	if spec.ResumeOf == "" {
+		spec.ResumeOf = job.Name
+	}
+	// Nextflow refuses a run name that is in the history of the launch
+	// directory, which also holds the session id of the run.
+	history, err := recordedHistory(ctx, clientset, restConfig, job.Namespace, spec, job.Name)
+	if err != nil {
+		fmt.Printf("Unable to read the Nextflow history, the run name is not checked against it: %v\n", err)
+	}
+	if spec.Session, err = lookupSessionID(ctx, clientset, job.Namespace, job.Name, history); err != nil {
+		return nil, err
+	}
+	resumed.JobName, err = derivedRunName(ctx, clientset, job.Namespace, spec.ResumeOf, history)
+	if err != nil {
+		return nil, err
+	}
+	// The worker pods of the resumed run are labelled with its own name.
+	data["nextflow.config"] = bytes.ReplaceAll(data["nextflow.config"],
+		[]byte(utils.PodMetadataEntry("label", "runName", job.Name)),
+		[]byte(utils.PodMetadataEntry("label", "runName", resumed.JobName)))
+	resumed.SetOption("-name", resumed.JobName)
+	resumed.RemoveOption("-resume", args.IsResumeValue)
+	resumed.Nextflow = append(resumed.Nextflow, "-resume", spec.Session)
+	spec.Args = resumed
+
+	fmt.Printf("Resuming run '%s' as '%s' (session %s)\n", job.Name, resumed.JobName, spec.Session)
+	return &launchPlan{launchSpec: spec, Namespace: job.Namespace, Data: data}, nil
+}
+
+func recordedSpec(job *batchv1.Job) (launchSpec, error) {
+	var spec launchSpec
+	raw := job.Annotations[annotationLaunchSpec]
+	if raw == "" {
+		return spec, fmt.Errorf("run '%s' has no recorded launch specification", job.Name)
+	}
+	err := json.Unmarshal([]byte(raw), &spec)
+	return spec, err
+}
+
+// derivedRunName returns the first of <origin>-r1, <origin>-r2, ... that is
+// not used by a job, a run record or an entry of the Nextflow history.
+func derivedRunName(ctx context.Context, clientset kubernetes.Interface, namespace, origin string, history [][]string) (string, error) {
+	inHistory := historyNames(history)
+	for n := 1; ; n++ {
+		name := utils.RunNameWithSuffix(origin, fmt.Sprintf("-r%d", n))
+		used, err := runNameUsed(ctx, clientset, namespace, name)
+		if err != nil || (!used && !inHistory[name]) {
+			return name, err
+		}
+	}
+}
+
+// recordedHistory reads the Nextflow history in the launch directory of a
+// recorded run, mounting the volumes the run was submitted with.
+func recordedHistory(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace string, spec launchSpec, runName string) ([][]string, error) {
+	podVolumes, mounts, err := utils.BuildVolumes(spec.Volumes)
+	if err != nil {
+		return nil, err
+	}
+	return readHistory(ctx, clientset, restConfig, namespace, helperSpec{
+		RunName: runName, Purpose: "history", Image: spec.Args.HeadImage, RunAsUser: runAsUser(spec.K8sConfig), Volumes: podVolumes, Mounts: mounts,
+	}, spec.LaunchDir)
+}
+
+// lookupSessionID finds the Nextflow session id of the run from the labels of
+// its worker pods or from the Nextflow history. -resume only accepts a
+// session id or last, so the run cannot be resumed when neither has it.
+func lookupSessionID(ctx context.Context, clientset kubernetes.Interface, namespace, runName string, history [][]string) (string, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: workerSelector(runName)})
+	if err == nil {
+		for _, pod := range pods.Items {
+			if session := strings.TrimPrefix(pod.Labels["nextflow.io/sessionId"], "uuid-"); args.IsSessionID(session) {
+				return session, nil
+			}
+		}
+	}
+	for _, fields := range history {
+		if len(fields) > 5 && fields[2] == runName && args.IsSessionID(fields[5]) {
+			return fields[5], nil
+		}
+	}
+	return "", fmt.Errorf("the Nextflow session id of run '%s' was found neither on its worker pods nor in the Nextflow history of its launch directory", runName)
+}
+
+// readHistory reads the Nextflow history file in the launch directory through
+// a helper pod. Each entry holds the timestamp, duration, run name, status,
+// revision, session id and command of a run. A missing file yields no
+// entries.
+func readHistory(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace string, spec helperSpec, launchDir string) ([][]string, error) {
+	helper, err := startHelperPod(ctx, clientset, namespace, spec)
+	if err != nil {
+		return nil, err
+	}
+	defer deleteHelperPod(clientset, namespace, helper.Name)
+
+	var history bytes.Buffer
+	historyFile := path.Join(launchDir, ".nextflow", "history")
+	script := fmt.Sprintf("if [ -f '%s' ]; then cat '%s'; fi", historyFile, historyFile)
+	err = execInPod(ctx, clientset, restConfig, namespace, helper.Name, "helper", []string{"sh", "-c", script}, nil, &history, nil)
+	if err != nil {
+		return nil, err
+	}
+	var entries [][]string
+	scanner := bufio.NewScanner(&history)
+	for scanner.Scan() {
+		entries = append(entries, strings.Split(scanner.Text(), "\t"))
+	}
+	return entries, nil
+}
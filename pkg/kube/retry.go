@@ -0,0 +1,123 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// headFailurePolicy fails the head job through the pod failure policy when the
+// head pod was disrupted (drain, eviction, preemption) or killed for running
+// out of memory. Pipeline failures fail the job through the backoff limit, so
+// the job condition tells the two apart.
+func headFailurePolicy(containerName string) *batchv1.PodFailurePolicy {
+	return &batchv1.PodFailurePolicy{
+		Rules: []batchv1.PodFailurePolicyRule{
+			{
+				Action:          batchv1.PodFailurePolicyActionFailJob,
+				OnPodConditions: []batchv1.PodFailurePolicyOnPodConditionsPattern{{Type: corev1.DisruptionTarget, Status: corev1.ConditionTrue}},
+			},
+			{
+				Action: batchv1.PodFailurePolicyActionFailJob,
+				OnExitCodes: &batchv1.PodFailurePolicyOnExitCodesRequirement{
+					ContainerName: &containerName,
+					Operator:      batchv1.PodFailurePolicyOnExitCodesOpIn,
+					Values:        []int32{137},
+				},
+			},
+		},
+	}
+}
+
+// infrastructureFailure waits for the failed job condition and reports whether
+// the head pod failed because of the infrastructure, and whether it was
+// killed for running out of memory. Exit code 137 alone is not enough: it is
+// also the exit code of a Nextflow killed after the grace period of a
+// cancelled run, which is never retried.
+func infrastructureFailure(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) (bool, bool, string) {
+	for i := 0; i < 30; i++ {
+		job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+		if err != nil {
+			return false, false, ""
+		}
+		for _, cond := range job.Status.Conditions {
+			if cond.Type != batchv1.JobFailed || cond.Status != corev1.ConditionTrue {
+				continue
+			}
+			if cond.Reason != batchv1.JobReasonPodFailurePolicy || job.Annotations[annotationCancelled] != "" {
+				return false, false, ""
+			}
+			oom, disrupted := headTermination(ctx, clientset, namespace, jobName)
+			return oom || disrupted, oom, cond.Message
+		}
+		time.Sleep(time.Second)
+	}
+	return false, false, ""
+}
+
+// headTermination reports whether the head pod was killed for running out of
+// memory, and whether it has the DisruptionTarget condition of a drained,
+// evicted or preempted pod.
+func headTermination(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) (bool, bool) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", jobName),
+	})
+	if err != nil {
+		return false, false
+	}
+	oom, disrupted := false, false
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Terminated != nil && status.State.Terminated.Reason == "OOMKilled" {
+				oom = true
+			}
+		}
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.DisruptionTarget && cond.Status == corev1.ConditionTrue {
+				disrupted = true
+			}
+		}
+	}
+	return oom, disrupted
+}
+
+// retryPlan returns the plan of the next attempt of a run whose head pod
+// failed because of the infrastructure, or nil when it should not be retried.
+func retryPlan(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, job *batchv1.Job, plan *launchPlan) *launchPlan {
+	infra, oom, reason := infrastructureFailure(ctx, clientset, job.Namespace, job.Name)
+	if !infra {
+		return nil
+	}
+
+	var overrides []string
+	if oom && plan.Args.HeadMemoryGrowth > 1 {
+		resources := prepareResources(plan.Args, plan.K8sConfig)
+		grow := func(memory resource.Quantity) string {
+			return resource.NewQuantity(int64(float64(memory.Value())*plan.Args.HeadMemoryGrowth), resource.BinarySI).String()
+		}
+		overrides = append(overrides, "-head-memory-request", grow(resources.Requests[corev1.ResourceMemory]))
+		if limit, ok := resources.Limits[corev1.ResourceMemory]; ok {
+			overrides = append(overrides, "-head-memory-limit", grow(limit))
+		}
+	}
+
+	next, err := resumePlan(ctx, clientset, restConfig, job, overrides)
+	if err != nil {
+		fmt.Printf("Unable to retry run '%s': %v\n", job.Name, err)
+		return nil
+	}
+	next.Args.HeadRetries = plan.Args.HeadRetries - 1
+
+	fmt.Printf("--- Head pod of run '%s' failed because of the infrastructure: %s ---\n", job.Name, reason)
+	memory := prepareResources(next.Args, next.K8sConfig).Requests[corev1.ResourceMemory]
+	fmt.Printf("--- Retrying as '%s' with -resume (head memory %s, %d retries left) ---\n",
+		next.Args.JobName, memory.String(), next.Args.HeadRetries)
+	return next
+}
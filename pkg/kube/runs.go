@@ -0,0 +1,114 @@
+package kube
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"nextflow-go/pkg/args"
+	"nextflow-go/pkg/utils"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Annotations the launcher records on the head job at submit time.
+const (
+	annotationArgs      = "nextflow-go/args"
+	annotationUser      = "nextflow-go/user"
+	annotationLaunchDir = "nextflow-go/launch-dir"
+	// annotationLaunchSpec holds the launchSpec of the run as JSON.
+	annotationLaunchSpec = "nextflow-go/launch-spec"
+	annotationHostname   = "nextflow-go/hostname"
+	annotationPipeline   = "nextflow-go/pipeline"
+	annotationRevision   = "nextflow-go/revision"
+	annotationVersion    = "nextflow-go/launcher-version"
+	annotationHeadImage  = "nextflow-go/head-image"
+	// annotationConfigHash is the SHA-256 of the final nextflow.config the
+	// head pod runs with.
+	annotationConfigHash = "nextflow-go/config-hash"
+)
+
+// annotationCancelled is set on the head job by kill and -on-interrupt
+// cancel before Nextflow is signalled, so a head pod killed after the grace
+// period is not taken for an infrastructure failure and retried.
+const annotationCancelled = "nextflow-go/cancelled"
+
+// runMetadata returns the annotations that record who launched what, set on
+// the head job and its pod.
+func runMetadata(a args.Args, finalConfig []byte) map[string]string {
+	hostname, _ := os.Hostname()
+	revision := a.Option("-r")
+	if revision == "" {
+		revision = a.Option("-revision")
+	}
+	configHash := sha256.Sum256(finalConfig)
+	metadata := map[string]string{
+		annotationArgs:       strings.Join(a.Nextflow, " "),
+		annotationUser:       utils.CurrentUser(),
+		annotationHostname:   hostname,
+		annotationPipeline:   a.Pipeline(),
+		annotationRevision:   revision,
+		annotationVersion:    utils.LauncherVersion(),
+		annotationHeadImage:  a.HeadImage,
+		annotationConfigHash: hex.EncodeToString(configHash[:]),
+	}
+	for key, value := range metadata {
+		if value == "" {
+			delete(metadata, key)
+		}
+	}
+	return metadata
+}
+
+// workerMetadata returns the labels and annotations of the worker pods: the
+// run name, the launching user and the -label values.
+func workerMetadata(a args.Args) (map[string]string, map[string]string) {
+	user := utils.CurrentUser()
+	labels := keyValues(a.Labels)
+	labels["runName"] = a.JobName
+	if len(validation.IsValidLabelValue(user)) == 0 {
+		labels[annotationUser] = user
+	}
+	return labels, map[string]string{annotationUser: user}
+}
+
+// keyValues turns the key=value pairs of -label or -annotation into a map.
+func keyValues(pairs []string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range pairs {
+		key, value, _ := strings.Cut(pair, "=")
+		values[key] = value
+	}
+	return values
+}
+
+// findRun looks up the head job the launcher created for the run.
+func findRun(ctx context.Context, clientset kubernetes.Interface, namespace, runName string) (*batchv1.Job, error) {
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=nextflow,runName=%s", runName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs.Items) == 0 {
+		return nil, fmt.Errorf("run '%s' not found in namespace '%s'", runName, namespace)
+	}
+	return &jobs.Items[0], nil
+}
+
+// configSecretName returns the name of the secret mounted as the config of
+// the head pod.
+func configSecretName(job *batchv1.Job) string {
+	for _, vol := range job.Spec.Template.Spec.Volumes {
+		if vol.Name == "nextflow-config" && vol.Secret != nil {
+			return vol.Secret.SecretName
+		}
+	}
+	return ""
+}
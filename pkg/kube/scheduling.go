@@ -0,0 +1,116 @@
+package kube
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"nextflow-go/pkg/args"
+	"nextflow-go/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// loadAffinity reads the affinity of the head pod from -head-affinity-file
+// or the k8s.head.affinityFile setting. The file holds a pod affinity in
+// YAML or JSON.
+func loadAffinity(a args.Args, k8sConfig map[string]string) (*corev1.Affinity, error) {
+	file := a.HeadAffinityFile
+	if file == "" {
+		file = utils.Stripped(k8sConfig["head.affinityFile"])
+	}
+	if file == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	affinity := &corev1.Affinity{}
+	if err := yaml.UnmarshalStrict(content, affinity); err != nil {
+		return nil, fmt.Errorf("invalid affinity in %s: %v", file, err)
+	}
+	return affinity, nil
+}
+
+// applyScheduling sets the placement of the head pod. Options of the launcher
+// take precedence over the k8s.head scope of the config; node selectors and
+// tolerations from both are combined.
+func applyScheduling(spec *corev1.PodSpec, a args.Args, k8sConfig map[string]string, affinity *corev1.Affinity) error {
+	selectors := splitSetting(k8sConfig["head.nodeSelector"])
+	selectors = append(selectors, a.HeadNodeSelector...)
+	for _, selector := range selectors {
+		key, value, ok := strings.Cut(selector, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid node selector '%s', expected key=value", selector)
+		}
+		if spec.NodeSelector == nil {
+			spec.NodeSelector = make(map[string]string)
+		}
+		spec.NodeSelector[key] = value
+	}
+
+	tolerations := splitSetting(k8sConfig["head.tolerations"])
+	tolerations = append(tolerations, a.HeadTolerations...)
+	for _, toleration := range tolerations {
+		t, err := parseToleration(toleration)
+		if err != nil {
+			return err
+		}
+		spec.Tolerations = append(spec.Tolerations, t)
+	}
+
+	spec.Affinity = affinity
+
+	spec.PriorityClassName = headPriorityClass(a, k8sConfig)
+
+	runtimeClass := a.HeadRuntimeClass
+	if runtimeClass == "" {
+		runtimeClass = utils.Stripped(k8sConfig["head.runtimeClass"])
+	}
+	if runtimeClass != "" {
+		spec.RuntimeClassName = &runtimeClass
+	}
+	return nil
+}
+
+// headPriorityClass returns the priority class of the head pod.
+func headPriorityClass(a args.Args, k8sConfig map[string]string) string {
+	if a.HeadPriorityClass != "" {
+		return a.HeadPriorityClass
+	}
+	return utils.Stripped(k8sConfig["head.priorityClass"])
+}
+
+// parseToleration parses a toleration in the syntax of kubectl taint:
+// key[=value][:effect]. Without a value the toleration matches any value of
+// the key, without an effect it matches all effects.
+func parseToleration(toleration string) (corev1.Toleration, error) {
+	spec, effect, _ := strings.Cut(toleration, ":")
+	key, value, hasValue := strings.Cut(spec, "=")
+	t := corev1.Toleration{Key: key, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffect(effect)}
+	if hasValue {
+		t.Operator, t.Value = corev1.TolerationOpEqual, value
+	}
+	switch t.Effect {
+	case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return t, fmt.Errorf("invalid toleration '%s', the effect must be NoSchedule, PreferNoSchedule or NoExecute", toleration)
+	}
+	if key == "" {
+		return t, fmt.Errorf("invalid toleration '%s', expected key[=value][:effect]", toleration)
+	}
+	return t, nil
+}
+
+// splitSetting splits a comma separated setting of the config.
+func splitSetting(value string) []string {
+	var items []string
+	for _, item := range strings.Split(utils.Stripped(strings.TrimSpace(value)), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,196 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"nextflow-go/pkg/args"
+	"nextflow-go/pkg/stage"
+	"nextflow-go/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// stageSecretLimit is the size of the largest staged archive that is shipped
+// inside the config secret. Larger archives are uploaded by a staging pod.
+const stageSecretLimit = 512 * 1024
+
+// A local pipeline directory larger than stageProjectWarning is staged with a
+// warning, one larger than stageProjectLimit is refused. Both usually mean
+// the pipeline was started from a directory with unrelated data.
+const (
+	stageProjectWarning = 50 * 1024 * 1024
+	stageProjectLimit   = 1024 * 1024 * 1024
+)
+
+// stageDir is the per-run directory below the launch directory that staged
+// files are unpacked into.
+func stageDir(launchDir, runName string) string {
+	return path.Join(launchDir, ".nextflow-go", runName)
+}
+
+// stageLocalPipeline stages the pipeline when it is a local script or project
+// directory and points the pipeline argument at the staged copy.
+func stageLocalPipeline(a *args.Args, st *stage.Stage) error {
+	pipeline := a.Pipeline()
+	if pipeline == "" {
+		return nil
+	}
+	info, err := os.Stat(pipeline)
+	if err != nil {
+		// not a local path, Nextflow pulls it from a repository
+		return nil
+	}
+
+	dir, script := pipeline, ""
+	if !info.IsDir() {
+		dir, script = filepath.Dir(pipeline), filepath.Base(pipeline)
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	before := st.Size()
+	if err := st.AddDir(dir, "project"); err != nil {
+		return err
+	}
+	switch size := st.Size() - before; {
+	case size > stageProjectLimit:
+		return fmt.Errorf("the local pipeline directory %s holds %d MiB, more than the limit of %d MiB; move the pipeline into its own directory or exclude data files in a .nfignore", dir, size>>20, stageProjectLimit>>20)
+	case size > stageProjectWarning:
+		fmt.Printf("Warning: staging %d MiB from the local pipeline directory %s, exclude files the pipeline does not need in a .nfignore\n", size>>20, dir)
+	}
+
+	staged := path.Join(st.Path("project"), script)
+	fmt.Printf("Staging local pipeline %s as %s\n", pipeline, staged)
+	a.Nextflow[a.PipelineIndex] = staged
+	return nil
+}
+
+// stageInputs stages local files passed as pipeline parameters on the command
+// line or as values in the params file, and rewrites them to the staged paths.
+func stageInputs(a *args.Args, st *stage.Stage, data map[string][]byte) error {
+	for i := 0; i < len(a.Nextflow); i++ {
+		arg := a.Nextflow[i]
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			staged, err := stageInput(st, name, value)
+			if err != nil {
+				return err
+			}
+			a.Nextflow[i] = name + "=" + staged
+			continue
+		}
+		if i+1 < len(a.Nextflow) && i+1 != a.PipelineIndex && !strings.HasPrefix(a.Nextflow[i+1], "-") {
+			staged, err := stageInput(st, arg, a.Nextflow[i+1])
+			if err != nil {
+				return err
+			}
+			a.Nextflow[i+1] = staged
+			i++
+		}
+	}
+
+	if a.ParamsFile == "" {
+		return nil
+	}
+	filename := filepath.Base(a.ParamsFile)
+	content, err := stage.RewriteParams(data[filename], filename, func(key, value string) (string, error) {
+		return stageInput(st, key, value)
+	})
+	if err != nil {
+		return fmt.Errorf("params file %s: %v", a.ParamsFile, err)
+	}
+	data[filename] = content
+	return nil
+}
+
+// stageInput stages value when it is a local file and returns the path the
+// parameter should use in the cluster.
+func stageInput(st *stage.Stage, param, value string) (string, error) {
+	if !stage.IsLocalFile(value) {
+		return value, nil
+	}
+	staged, err := st.AddInput(value)
+	if err != nil {
+		return value, err
+	}
+	fmt.Printf("Staging input %s %s as %s\n", param, value, staged)
+	return staged, nil
+}
+
+// uploadStage ships the staged files into the cluster. Small archives are
+// added to the config secret data and the returned command unpacks them in
+// the head pod; larger ones are unpacked by a staging pod right away.
+func uploadStage(ctx context.Context, clientset kubernetes.Interface, restConfig *rest.Config, namespace string, st *stage.Stage, helper helperSpec, data map[string][]byte, dryRun bool) (string, error) {
+	if st.Empty() {
+		return "", nil
+	}
+	dir := utils.ShellQuote(st.Dir)
+
+	// Only archives that may fit into the secret are built in memory,
+	// larger ones are streamed to the staging pod.
+	if st.Size() <= stageSecretLimit {
+		archive, err := st.Bytes()
+		if err != nil {
+			return "", err
+		}
+		if len(archive) <= stageSecretLimit {
+			data["stage.tar.gz"] = archive
+			return fmt.Sprintf("mkdir -p %s && tar xzf /etc/nextflow/stage.tar.gz -C %s", dir, dir), nil
+		}
+	}
+
+	if !onVolume(st.Dir, helper.Volumes, helper.Mounts, true) {
+		return "", fmt.Errorf("staged files (%d bytes) are too large for the config secret and %s is not on a writable shared volume", st.Size(), st.Dir)
+	}
+	if dryRun {
+		fmt.Printf("Would upload %d staged files (%d bytes) to %s\n", len(st.Files), st.Size(), st.Dir)
+		return "", nil
+	}
+
+	fmt.Printf("Uploading %d staged files (%d bytes) to %s...\n", len(st.Files), st.Size(), st.Dir)
+	pod, err := startHelperPod(ctx, clientset, namespace, helper)
+	if err != nil {
+		return "", err
+	}
+	defer deleteHelperPod(clientset, namespace, pod.Name)
+
+	script := fmt.Sprintf("mkdir -p %s && tar xzf - -C %s", dir, dir)
+	archive, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(st.WriteArchive(writer))
+	}()
+	err = execInPod(ctx, clientset, restConfig, namespace, pod.Name, "helper", []string{"/bin/sh", "-c", script}, archive, nil, os.Stderr)
+	archive.Close()
+	return "", err
+}
+
+// onVolume reports whether dir lies on one of the mounted PVC or NFS
+// volumes, which are shared with the head pod. With writable set, read-only
+// mounts are not considered.
+func onVolume(dir string, volumes []corev1.Volume, mounts []corev1.VolumeMount, writable bool) bool {
+	shared := make(map[string]bool)
+	for _, vol := range volumes {
+		shared[vol.Name] = vol.PersistentVolumeClaim != nil || vol.NFS != nil
+	}
+	for _, mount := range mounts {
+		if !shared[mount.Name] || (writable && mount.ReadOnly) {
+			continue
+		}
+		mountPath := strings.TrimSuffix(mount.MountPath, "/")
+		if dir == mountPath || strings.HasPrefix(dir, mountPath+"/") {
+			return true
+		}
+	}
+	return false
+}
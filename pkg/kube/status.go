@@ -0,0 +1,135 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"nextflow-go/pkg/args"
+	"nextflow-go/pkg/utils"
+
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// RunStatus is the status and metadata of one run as printed by
+// `nextflow-go status`.
+type RunStatus struct {
+	RunInfo
+	Hostname        string            `json:"hostname,omitempty"`
+	Pipeline        string            `json:"pipeline,omitempty"`
+	Revision        string            `json:"revision,omitempty"`
+	LauncherVersion string            `json:"launcherVersion,omitempty"`
+	ConfigHash      string            `json:"configHash,omitempty"`
+	LaunchDir       string            `json:"launchDir,omitempty"`
+	ExitCode        *int              `json:"exitCode,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+// Status prints the status and the recorded metadata of a run.
+func Status() {
+	args := args.ParseStatusArgs()
+	namespace := resolveNamespace(args.Namespace, loadK8sConfig(args.ConfigName))
+
+	clientset, _, err := newClient()
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+
+	job, err := findRecordedRun(ctx, clientset, namespace, args.RunName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	status := runStatusOf(job)
+	if _, err := findRun(ctx, clientset, namespace, args.RunName); err != nil {
+		status.Removed = true
+		if status.Status == "Pending" || status.Status == "Running" {
+			// The launcher did not see the run finish.
+			status.Status, status.Duration = "Unknown", ""
+		}
+		if exitCode, ok := recordedExitCode(ctx, clientset, namespace, job.Name); ok {
+			status.ExitCode = &exitCode
+		}
+	} else if jobFinished(job) {
+		exitCode := headExitCode(ctx, clientset, namespace, job.Name)
+		status.ExitCode = &exitCode
+	}
+
+	if args.Output == "json" {
+		utils.PrintAsJSON(status)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	row := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", name, value)
+		}
+	}
+	state := status.Status
+	if status.Removed {
+		state += " (removed)"
+	}
+	row("Name", status.Name)
+	row("Namespace", status.Namespace)
+	row("Status", state)
+	if status.ExitCode != nil {
+		row("Exit code", fmt.Sprint(*status.ExitCode))
+	}
+	row("Started", status.StartTime.Local().Format("2006-01-02 15:04:05"))
+	row("Duration", status.Duration)
+	row("User", status.User)
+	row("Hostname", status.Hostname)
+	row("Pipeline", status.Pipeline)
+	row("Revision", status.Revision)
+	row("Arguments", status.Args)
+	row("Head image", status.HeadImage)
+	row("Launcher version", status.LauncherVersion)
+	row("Config hash", status.ConfigHash)
+	row("Launch directory", status.LaunchDir)
+	row("Labels", joinKeyValues(status.Labels))
+	row("Annotations", joinKeyValues(status.Annotations))
+	w.Flush()
+}
+
+// runStatusOf collects the status and metadata of the head job. Labels and
+// annotations of the launcher itself are left out of Labels and Annotations.
+func runStatusOf(job *batchv1.Job) RunStatus {
+	annotations := job.Annotations
+	status := RunStatus{
+		RunInfo:         runInfo(job),
+		Hostname:        annotations[annotationHostname],
+		Pipeline:        annotations[annotationPipeline],
+		Revision:        annotations[annotationRevision],
+		LauncherVersion: annotations[annotationVersion],
+		ConfigHash:      annotations[annotationConfigHash],
+		LaunchDir:       annotations[annotationLaunchDir],
+		Labels:          make(map[string]string),
+		Annotations:     make(map[string]string),
+	}
+	for key, value := range job.Labels {
+		if key != "app" && key != "runName" {
+			status.Labels[key] = value
+		}
+	}
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, "nextflow-go/") {
+			status.Annotations[key] = value
+		}
+	}
+	return status
+}
+
+func joinKeyValues(values map[string]string) string {
+	pairs := make([]string, 0, len(values))
+	for key, value := range values {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
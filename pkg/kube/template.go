@@ -0,0 +1,179 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// headContainerAlias is the container name that refers to the head
+// container in a pod template, whose real name is the run name.
+const headContainerAlias = "head"
+
+// loadPodTemplate reads a partial Job, PodTemplate or PodTemplateSpec in YAML
+// or JSON and returns it as a strategic merge patch of the head job.
+func loadPodTemplate(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var template map[string]interface{}
+	if err := yaml.Unmarshal(content, &template); err != nil {
+		return "", fmt.Errorf("invalid pod template %s: %v", file, err)
+	}
+	kind, _ := template["kind"].(string)
+	delete(template, "kind")
+	delete(template, "apiVersion")
+
+	patch := template
+	spec, _ := template["spec"].(map[string]interface{})
+	switch {
+	case kind == "Job" || (kind == "" && spec != nil && spec["template"] != nil):
+	case kind == "PodTemplate":
+		patch = map[string]interface{}{"spec": map[string]interface{}{"template": template["template"]}}
+	case kind == "" || kind == "PodTemplateSpec":
+		patch = map[string]interface{}{"spec": map[string]interface{}{"template": template}}
+	default:
+		return "", fmt.Errorf("pod template %s is a %s, expected a Job, PodTemplate or PodTemplateSpec", file, kind)
+	}
+	encoded, err := json.Marshal(patch)
+	return string(encoded), err
+}
+
+// applyPodTemplate patches the head job with the pod template. Changes of
+// fields the launcher depends on are rejected unless force is set.
+func applyPodTemplate(job *batchv1.Job, template string, force bool) (*batchv1.Job, error) {
+	var patch map[string]interface{}
+	if err := json.Unmarshal([]byte(template), &patch); err != nil {
+		return nil, err
+	}
+	headName := job.Spec.Template.Spec.Containers[0].Name
+	containers, _ := nestedList(patch, "spec", "template", "spec", "containers")
+	for _, c := range containers {
+		if container, ok := c.(map[string]interface{}); ok && container["name"] == headContainerAlias {
+			container["name"] = headName
+		}
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	original, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := strategicpatch.StrategicMergePatch(original, patchBytes, batchv1.Job{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to apply the head pod template: %v", err)
+	}
+	patched := &batchv1.Job{}
+	if err := json.Unmarshal(merged, patched); err != nil {
+		return nil, err
+	}
+
+	// The launcher expects the head container first.
+	podSpec := &patched.Spec.Template.Spec
+	for i, container := range podSpec.Containers {
+		if container.Name == headName && i > 0 {
+			podSpec.Containers = append([]corev1.Container{container}, append(podSpec.Containers[:i:i], podSpec.Containers[i+1:]...)...)
+			break
+		}
+	}
+
+	if changes := protectedChanges(job, patched); len(changes) > 0 {
+		if !force {
+			return nil, fmt.Errorf("the head pod template changes fields the launcher depends on: %s; pass -head-pod-template-force to apply it anyway", strings.Join(changes, ", "))
+		}
+		fmt.Printf("Warning: the head pod template changes %s\n", strings.Join(changes, ", "))
+	}
+	return patched, nil
+}
+
+// protectedChanges lists the fields the launcher depends on that differ
+// between the generated and the patched job.
+func protectedChanges(before, after *batchv1.Job) []string {
+	var changes []string
+	if before.Name != after.Name || before.Namespace != after.Namespace {
+		changes = append(changes, "the job name")
+	}
+	for _, label := range []string{"app", "runName"} {
+		if before.Labels[label] != after.Labels[label] {
+			changes = append(changes, "label "+label)
+		}
+	}
+	for key, value := range before.Annotations {
+		if after.Annotations[key] != value {
+			changes = append(changes, "annotation "+key)
+		}
+	}
+	if before.Spec.Template.Spec.RestartPolicy != after.Spec.Template.Spec.RestartPolicy {
+		changes = append(changes, "the restart policy")
+	}
+
+	head := before.Spec.Template.Spec.Containers[0]
+	patchedHead := findContainer(after.Spec.Template.Spec.Containers, head.Name)
+	if patchedHead == nil {
+		return append(changes, "the head container (removed)")
+	}
+	if !reflect.DeepEqual(head.Command, patchedHead.Command) || !reflect.DeepEqual(head.Args, patchedHead.Args) {
+		changes = append(changes, "the command of the head container")
+	}
+	if !reflect.DeepEqual(findMount(head.VolumeMounts, "nextflow-config"), findMount(patchedHead.VolumeMounts, "nextflow-config")) {
+		changes = append(changes, "the mount of the config volume")
+	}
+	if !reflect.DeepEqual(findVolume(before.Spec.Template.Spec.Volumes, "nextflow-config"), findVolume(after.Spec.Template.Spec.Volumes, "nextflow-config")) {
+		changes = append(changes, "the config volume")
+	}
+	return changes
+}
+
+func findContainer(containers []corev1.Container, name string) *corev1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+func findMount(mounts []corev1.VolumeMount, name string) *corev1.VolumeMount {
+	for i := range mounts {
+		if mounts[i].Name == name {
+			return &mounts[i]
+		}
+	}
+	return nil
+}
+
+func findVolume(volumes []corev1.Volume, name string) *corev1.Volume {
+	for i := range volumes {
+		if volumes[i].Name == name {
+			return &volumes[i]
+		}
+	}
+	return nil
+}
+
+// nestedList returns the list at the path of a decoded JSON object.
+func nestedList(obj map[string]interface{}, path ...string) ([]interface{}, bool) {
+	for i, key := range path {
+		if i == len(path)-1 {
+			list, ok := obj[key].([]interface{})
+			return list, ok
+		}
+		next, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		obj = next
+	}
+	return nil, false
+}
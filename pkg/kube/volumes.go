@@ -0,0 +1,181 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nextflow-go/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// defaultPVCSize is the size of PVCs created by -create-missing-pvcs.
+	defaultPVCSize = "100Gi"
+	// pvcBindTimeout bounds how long a created PVC may take to become Bound.
+	pvcBindTimeout = 5 * time.Minute
+)
+
+// rwoProvisioners are provisioners of block storage that cannot be mounted
+// by several pods on different nodes.
+var rwoProvisioners = map[string]bool{
+	"ebs.csi.aws.com":              true,
+	"kubernetes.io/aws-ebs":        true,
+	"pd.csi.storage.gke.io":        true,
+	"kubernetes.io/gce-pd":         true,
+	"disk.csi.azure.com":           true,
+	"kubernetes.io/azure-disk":     true,
+	"cinder.csi.openstack.org":     true,
+	"rbd.csi.ceph.com":             true,
+	"rancher.io/local-path":        true,
+	"openebs.io/local":             true,
+	"kubernetes.io/no-provisioner": true,
+}
+
+// missingVolumes returns the PVCs to create: the new: volumes of -v and,
+// with -create-missing-pvcs, every other writable PVC of the run.
+func missingVolumes(volumeArgs, volumes []string, createMissing bool) []utils.Volume {
+	var create []utils.Volume
+	requested := make(map[string]bool)
+	for _, spec := range volumeArgs {
+		vol, err := utils.ParseVolume(spec)
+		if err != nil {
+			panic(err)
+		}
+		if vol.Create && !requested[vol.Name] {
+			requested[vol.Name] = true
+			create = append(create, vol)
+		}
+	}
+	if !createMissing {
+		return create
+	}
+	for _, spec := range volumes {
+		vol, err := utils.ParseVolume(spec)
+		if err != nil {
+			panic(err)
+		}
+		if vol.Kind == utils.VolumePVC && !vol.ReadOnly && !requested[vol.Name] {
+			requested[vol.Name] = true
+			vol.Create, vol.Size = true, defaultPVCSize
+			create = append(create, vol)
+		}
+	}
+	return create
+}
+
+// provisionVolumes creates the PVCs that do not exist yet as ReadWriteMany
+// volumes and waits until they are bound. In a dry run the PVCs are only
+// added to the manifest.
+func provisionVolumes(ctx context.Context, clientset kubernetes.Interface, namespace, runName string, volumes []utils.Volume, dryRun *manifest) error {
+	for _, vol := range volumes {
+		var class *storagev1.StorageClass
+		if dryRun == nil {
+			_, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, vol.Name, metav1.GetOptions{})
+			if err == nil {
+				continue
+			}
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			class, err = storageClass(ctx, clientset, vol.StorageClass)
+			if err != nil {
+				return err
+			}
+		}
+		if class != nil && rwoProvisioners[class.Provisioner] {
+			return fmt.Errorf("refusing to create PVC %s: storage class %s (%s) cannot provide ReadWriteMany volumes, which the worker pods of a pipeline need; pass a storage class with shared storage as new:%s:%s:<class>:%s",
+				vol.Name, class.Name, class.Provisioner, vol.Name, vol.Size, vol.MountPath)
+		}
+
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: vol.Name,
+				Labels: map[string]string{
+					"app":     "nextflow-go-volume",
+					"runName": runName,
+				},
+				Annotations: map[string]string{
+					annotationUser: utils.CurrentUser(),
+				},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(vol.Size)},
+				},
+			},
+		}
+		if vol.StorageClass != "" {
+			pvc.Spec.StorageClassName = &vol.StorageClass
+		}
+
+		if dryRun != nil {
+			dryRun.add(pvc, "v1", "PersistentVolumeClaim")
+			continue
+		}
+		if _, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		fmt.Printf("Created PVC %s (%s, ReadWriteMany)\n", vol.Name, vol.Size)
+
+		if class != nil && class.VolumeBindingMode != nil && *class.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer {
+			fmt.Printf("PVC %s will be bound when the head pod is scheduled\n", vol.Name)
+			continue
+		}
+		if err := waitForBound(ctx, clientset, namespace, vol.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storageClass returns the named storage class or the default one. It
+// returns nil when there is no default class.
+func storageClass(ctx context.Context, clientset kubernetes.Interface, name string) (*storagev1.StorageClass, error) {
+	if name != "" {
+		return clientset.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+	}
+	classes, err := clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsForbidden(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for i, class := range classes.Items {
+		if class.Annotations["storageclass.kubernetes.io/is-default-class"] == "true" {
+			return &classes.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// waitForBound waits until the PVC is bound and checks that the bound volume
+// can be mounted read-write by many pods.
+func waitForBound(ctx context.Context, clientset kubernetes.Interface, namespace, name string) error {
+	fmt.Printf("Waiting for PVC %s to be bound...\n", name)
+	deadline := time.Now().Add(pvcBindTimeout)
+	for time.Now().Before(deadline) {
+		pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pvc.Status.Phase == corev1.ClaimBound {
+			for _, mode := range pvc.Status.AccessModes {
+				if mode == corev1.ReadWriteMany {
+					return nil
+				}
+			}
+			return fmt.Errorf("PVC %s was bound to a volume without ReadWriteMany access (%v)", name, pvc.Status.AccessModes)
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("PVC %s was not bound within %s, check `kubectl describe pvc %s -n %s`", name, pvcBindTimeout, name, namespace)
+}
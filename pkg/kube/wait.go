@@ -0,0 +1,114 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"nextflow-go/pkg/args"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// exitTimeout is the exit code of `nextflow-go wait` when the timeout
+// expires before the run finishes, as with timeout(1).
+const exitTimeout = 124
+
+// Wait blocks until a submitted run finishes and exits with the exit code of
+// Nextflow in its head pod, without streaming the output.
+func Wait() {
+	args := args.ParseWaitArgs()
+	namespace := resolveNamespace(args.Namespace, loadK8sConfig(args.ConfigName))
+
+	clientset, _, err := newClient()
+	if err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+
+	job, err := findRun(ctx, clientset, namespace, args.RunName)
+	if err != nil {
+		if exitCode, ok := recordedExitCode(ctx, clientset, namespace, args.RunName); ok {
+			fmt.Printf("Run '%s' already finished with exit code %d.\n", args.RunName, exitCode)
+			os.Exit(exitCode)
+		}
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	waitCtx := ctx
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, args.Timeout)
+		defer cancel()
+	}
+	if err := waitForJob(waitCtx, clientset, job); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			fmt.Printf("Run '%s' did not finish within %s.\n", job.Name, args.Timeout)
+			os.Exit(exitTimeout)
+		}
+		fmt.Printf("Unable to wait for run '%s': %v\n", job.Name, err)
+		os.Exit(1)
+	}
+
+	exitCode := reportExit(ctx, clientset, namespace, job.Name)
+	recordExit(ctx, clientset, job, exitCode)
+	os.Exit(exitCode)
+}
+
+// waitForJob watches the job until it completes or fails.
+func waitForJob(ctx context.Context, clientset kubernetes.Interface, job *batchv1.Job) error {
+	jobs := clientset.BatchV1().Jobs(job.Namespace)
+	resourceVersion := job.ResourceVersion
+	for !jobFinished(job) {
+		watcher, err := jobs.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", job.Name).String(),
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			return err
+		}
+		for event := range watcher.ResultChan() {
+			switch event.Type {
+			case watch.Deleted:
+				watcher.Stop()
+				return fmt.Errorf("the head job was deleted")
+			case watch.Added, watch.Modified:
+				job = event.Object.(*batchv1.Job)
+				resourceVersion = job.ResourceVersion
+			}
+			if jobFinished(job) {
+				break
+			}
+		}
+		watcher.Stop()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !jobFinished(job) {
+			// The watch was closed by the server; continue from the
+			// current state of the job.
+			if job, err = jobs.Get(ctx, job.Name, metav1.GetOptions{}); err != nil {
+				return err
+			}
+			resourceVersion = job.ResourceVersion
+		}
+	}
+	return nil
+}
+
+// jobFinished reports whether the job has completed or failed.
+func jobFinished(job *batchv1.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package stage
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultIgnores are never staged: VCS metadata and the local Nextflow
+// cache, work directory and logs.
+var defaultIgnores = []string{".git/", ".nextflow/", ".nextflow.log*", "work/"}
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Ignore holds the .gitignore and .nfignore rules of a project directory.
+type Ignore struct {
+	rules []ignoreRule
+}
+
+// LoadIgnore reads .gitignore and .nfignore from the root of dir.
+func LoadIgnore(dir string) (*Ignore, error) {
+	ignore := &Ignore{}
+	for _, pattern := range defaultIgnores {
+		ignore.add(pattern)
+	}
+	for _, name := range []string{".gitignore", ".nfignore"} {
+		file, err := os.Open(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			ignore.add(scanner.Text())
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ignore, nil
+}
+
+func (ig *Ignore) add(pattern string) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return
+	}
+	rule := ignoreRule{}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	expr := globToRegexp(pattern)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "(^|/)" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return
+	}
+	rule.re = re
+	ig.rules = append(ig.rules, rule)
+}
+
+// Match reports whether the slash separated path relative to the project
+// root is ignored. The last matching rule wins, as in git.
+func (ig *Ignore) Match(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range ig.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				b.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
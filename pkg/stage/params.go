@@ -0,0 +1,160 @@
+package stage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IsLocalFile reports whether value names an existing regular file on the
+// local machine. URLs and remote paths are never local.
+func IsLocalFile(value string) bool {
+	if value == "" || strings.Contains(value, "://") {
+		return false
+	}
+	info, err := os.Stat(value)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// AddInput stages a local input file below inputs/ and returns its in-cluster
+// path. A file that is referenced several times is staged only once.
+func (s *Stage) AddInput(local string) (string, error) {
+	abs, err := filepath.Abs(local)
+	if err != nil {
+		return "", err
+	}
+	if staged, ok := s.inputs[abs]; ok {
+		return staged, nil
+	}
+
+	base := filepath.Base(abs)
+	name := path.Join("inputs", base)
+	for n := 2; s.usedInput(name); n++ {
+		name = path.Join("inputs", fmt.Sprintf("%d-%s", n, base))
+	}
+	if err := s.AddFile(abs, name); err != nil {
+		return "", err
+	}
+
+	if s.inputs == nil {
+		s.inputs = map[string]string{}
+	}
+	s.inputs[abs] = s.Path(name)
+	return s.inputs[abs], nil
+}
+
+func (s *Stage) usedInput(name string) bool {
+	for _, file := range s.Files {
+		if file == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RewriteParams applies rewrite to every string value of a JSON or YAML
+// params file. The original content is returned when nothing changed, so
+// comments and formatting are kept.
+func RewriteParams(content []byte, filename string, rewrite func(key, value string) (string, error)) ([]byte, error) {
+	changed := false
+	tracked := func(key, value string) (string, error) {
+		rewritten, err := rewrite(key, value)
+		if rewritten != value {
+			changed = true
+		}
+		return rewritten, err
+	}
+
+	if strings.HasSuffix(filename, ".yml") || strings.HasSuffix(filename, ".yaml") {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, err
+		}
+		if err := rewriteNode("", &doc, tracked); err != nil || !changed {
+			return content, err
+		}
+		return yaml.Marshal(&doc)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var params interface{}
+	if err := decoder.Decode(&params); err != nil {
+		return nil, err
+	}
+	params, err := rewriteValues("", params, tracked)
+	if err != nil || !changed {
+		return content, err
+	}
+	return json.MarshalIndent(params, "", "  ")
+}
+
+func rewriteValues(key string, value interface{}, rewrite func(key, value string) (string, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return rewrite(key, v)
+	case map[string]interface{}:
+		for k, item := range v {
+			rewritten, err := rewriteValues(joinKey(key, k), item, rewrite)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = rewritten
+		}
+	case []interface{}:
+		for i, item := range v {
+			rewritten, err := rewriteValues(fmt.Sprintf("%s[%d]", key, i), item, rewrite)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = rewritten
+		}
+	}
+	return value, nil
+}
+
+func rewriteNode(key string, node *yaml.Node, rewrite func(key, value string) (string, error)) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := rewriteNode(key, child, rewrite); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := rewriteNode(joinKey(key, node.Content[i].Value), node.Content[i+1], rewrite); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			if err := rewriteNode(fmt.Sprintf("%s[%d]", key, i), child, rewrite); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.ShortTag() != "!!str" {
+			return nil
+		}
+		rewritten, err := rewrite(key, node.Value)
+		if err != nil {
+			return err
+		}
+		node.Value = rewritten
+	}
+	return nil
+}
+
+func joinKey(key, name string) string {
+	if key == "" {
+		return name
+	}
+	return key + "." + name
+}
@@ -0,0 +1,138 @@
+package stage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Stage collects local files that have to be copied into the cluster before
+// the head pod starts. The files are packed into a gzipped tar that is
+// unpacked into Dir, a per-run directory below the launch directory. The
+// archive is only written when it is requested, so it can be streamed.
+type Stage struct {
+	Dir   string
+	Files []string
+
+	inputs map[string]string
+	local  []string
+	size   int64
+}
+
+// New returns an empty stage that unpacks into dir.
+func New(dir string) *Stage {
+	return &Stage{Dir: dir}
+}
+
+// Path returns the in-cluster path of a staged name.
+func (s *Stage) Path(name string) string {
+	return path.Join(s.Dir, name)
+}
+
+// Empty reports whether nothing has been staged.
+func (s *Stage) Empty() bool {
+	return len(s.Files) == 0
+}
+
+// Size returns the total size of the staged files.
+func (s *Stage) Size() int64 {
+	return s.size
+}
+
+// AddFile stages the local file under name.
+func (s *Stage) AddFile(local, name string) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	s.Files = append(s.Files, name)
+	s.local = append(s.local, local)
+	s.size += info.Size()
+	return nil
+}
+
+// AddDir stages the content of the local directory under name, skipping the
+// files excluded by its .gitignore and .nfignore.
+func (s *Stage) AddDir(local, name string) error {
+	ignore, err := LoadIgnore(local)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(local, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(local, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignore.Match(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() {
+			// dangling links, sockets and the like are not staged
+			return nil
+		}
+		return s.AddFile(p, path.Join(name, rel))
+	})
+}
+
+// WriteArchive writes the staged files as a gzipped tar to w.
+func (s *Stage) WriteArchive(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for i, name := range s.Files {
+		if err := writeFile(tw, s.local[i], name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Bytes returns the archive of the staged files.
+func (s *Stage) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	err := s.WriteArchive(&buf)
+	return buf.Bytes(), err
+}
+
+func writeFile(tw *tar.Writer, local, name string) error {
+	file, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// a file that grows while it is copied is cut at the size in its header
+	_, err = io.CopyN(tw, file, info.Size())
+	return err
+}
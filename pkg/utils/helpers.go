@@ -3,8 +3,9 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"os"
+	"os/user"
 	"strings"
-	"regexp"
 
 	"github.com/brianvoe/gofakeit/v7"
 
@@ -17,7 +18,15 @@ func Int64Ptr(i int64) *int64 { return &i }
 func Int32Ptr(i int32) *int32 { return &i }
 
 func GenerateRandomName() string {
-	return strings.ToLower(strings.ReplaceAll(fmt.Sprintf("%s-%s", gofakeit.Adjective(), gofakeit.Noun()), " ", "-"))
+	return SanitizeRunName(fmt.Sprintf("%s-%s", gofakeit.Adjective(), gofakeit.Noun()))
+}
+
+// CurrentUser returns the name of the local user launching the run.
+func CurrentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
 }
 
 func PrintAsJSON(obj interface{}) {
@@ -29,118 +38,245 @@ func PrintAsJSON(obj interface{}) {
 	fmt.Println(string(b))
 }
 
-func NormalizeVolumes(args []string, k8sConfig map[string]string) []string {
-	var volumes []string
+// NormalizeVolumes applies the -v volumes to the k8s config and returns the
+// volumes of the head pod. The first writable PVC becomes the Nextflow
+// storage, the others are added to the k8s.pod directive so worker pods
+// mount them too. Volumes already in the config are mounted on the head pod.
+func NormalizeVolumes(args []string, k8sConfig map[string]string) ([]string, error) {
+	var volumes, headOnly []string
+	storageSet := false
 
-	for i, v := range args {
-		parts := strings.Split(v, ":")
-		if len(parts) != 2 {
-			continue
+	pod, err := ParsePod(k8sConfig["pod"])
+	if err != nil {
+		return nil, err
+	}
+	dedupePodVolumes(pod)
+
+	for _, v := range args {
+		vol, err := ParseVolume(v)
+		if err != nil {
+			return nil, err
 		}
-		if i == 0 {
-			k8sConfig["storageClaimName"] = "'" + parts[0] + "'"
-			k8sConfig["storageMountPath"] = "'" + parts[1] + "'"
-		} else {
-			target := fmt.Sprintf("volumeClaim:'%s', mountPath:'%s'", parts[0], parts[1])
-
-			reDoubleOpen := regexp.MustCompile(`\[\s*\[`)
-			reDoubleClose := regexp.MustCompile(`\]\s*\]`)
-			reEntrySeparator := regexp.MustCompile(`\]\s*,\s*\[`)
-
-			config := reDoubleOpen.ReplaceAllString(k8sConfig["pod"], "[[")
-			config = reDoubleClose.ReplaceAllString(config, "]]" )
-			config = reEntrySeparator.ReplaceAllString(config, "],[")
-
-			entries := []string{}
-			if strings.TrimSpace(config) != "" {
-				trimmed := strings.TrimPrefix(config, "[[")
-				trimmed = strings.TrimSuffix(trimmed, "]]")
-				entries = strings.Split(trimmed, "],[")
+		if !storageSet && vol.Kind == VolumePVC && !vol.ReadOnly {
+			k8sConfig["storageClaimName"] = "'" + vol.Name + "'"
+			k8sConfig["storageMountPath"] = "'" + vol.MountPath + "'"
+			if vol.SubPath != "" {
+				k8sConfig["storageSubPath"] = "'" + vol.SubPath + "'"
+			} else {
+				delete(k8sConfig, "storageSubPath")
 			}
+			storageSet = true
+			continue
+		}
+		target, ok := vol.PodEntry()
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Warning: volume %s is mounted on the head pod only, Nextflow cannot mount %s volumes on worker pods\n", v, vol.Kind)
+			headOnly = append(headOnly, vol.String())
+			continue
+		}
 
-			found := false
-			for _, entry := range entries {
-				if strings.Contains(entry, fmt.Sprintf("volumeClaim:'%s'", parts[0])) &&
-					strings.Contains(entry, fmt.Sprintf("mountPath:'%s'", parts[1])) {
-					found = true
-					break
-				}
+		found := false
+		pod.Remove(func(entry PodEntry) bool {
+			existing, ok := podEntryVolume(entry)
+			if !ok || existing.MountPath != vol.MountPath {
+				return false
 			}
-
-			if !found {
-				entries = append(entries, target)
+			if existing.String() == vol.String() {
+				found = true
+				return false
+			}
+			fmt.Fprintf(os.Stderr, "Warning: volume %s replaces %s of the k8s.pod setting\n", v, entry.Raw)
+			return true
+		})
+		if !found {
+			if err := pod.Add(target); err != nil {
+				return nil, err
 			}
-
-			k8sConfig["pod"] = "[[" + strings.Join(entries, "],[") + "]]"
 		}
 	}
+	if k8sConfig["pod"] != "" || len(pod.Entries) > 0 {
+		k8sConfig["pod"] = pod.String()
+	}
 
         if Stripped(k8sConfig["storageClaimName"]) != "" && Stripped(k8sConfig["storageMountPath"]) != "" {
- 	        volumes = append(volumes, fmt.Sprintf("%s:%s",
-		        Stripped(k8sConfig["storageClaimName"]),
-		        Stripped(k8sConfig["storageMountPath"])))
+                storage := Volume{
+                        Kind:      VolumePVC,
+                        Name:      Stripped(k8sConfig["storageClaimName"]),
+                        SubPath:   Stripped(k8sConfig["storageSubPath"]),
+                        MountPath: Stripped(k8sConfig["storageMountPath"]),
+                }
+ 	        volumes = append(volumes, storage.String())
         }
 
-	pattern := `(?i)\[\s*volumeClaim\s*:\s*['\"]([^'\"]+)['\"]\s*,\s*mountPath\s*:\s*['\"]([^'\"]+)['\"]\s*\]`
-	re := regexp.MustCompile(pattern)
-	matches := re.FindAllStringSubmatch(k8sConfig["pod"], -1)
-	for _, match := range matches {
-		if len(match) == 3 {
-                        newVolume := fmt.Sprintf("%s:%s", match[1], match[2])
-                        var exists bool
-                        for _, v := range volumes {
-                                if v == newVolume {
-                                        exists = true
-                                        break
-                                }
-                        }
-                        if !exists {
-                                volumes = append(volumes, newVolume)
+	var mounted []string
+	for _, entry := range pod.Entries {
+		if vol, ok := podEntryVolume(entry); ok {
+			mounted = append(mounted, vol.String())
+		}
+	}
+	for _, newVolume := range append(mounted, headOnly...) {
+                var exists bool
+                for _, v := range volumes {
+                        if v == newVolume {
+                                exists = true
+                                break
                         }
+                }
+                if !exists {
+                        volumes = append(volumes, newVolume)
+                }
+	}
+
+	return volumes, nil
+}
+
+// podEntryVolume returns the volume mounted by an entry of the k8s.pod
+// directive, or false when the entry is not a volume.
+func podEntryVolume(entry PodEntry) (Volume, bool) {
+	vol := Volume{MountPath: entry.StringValue("mountPath")}
+	if vol.MountPath == "" {
+		return vol, false
+	}
+	switch {
+	case entry.StringValue("volumeClaim") != "":
+		vol.Kind = VolumePVC
+		vol.Name = entry.StringValue("volumeClaim")
+		vol.SubPath = entry.StringValue("subPath")
+		readOnly, _ := entry.Value("readOnly")
+		vol.ReadOnly = readOnly == "true"
+	case entry.StringValue("config") != "":
+		vol.Kind = VolumeConfigMap
+		vol.Name = entry.StringValue("config")
+		vol.ReadOnly = true
+	case entry.StringValue("secret") != "":
+		vol.Kind = VolumeSecret
+		vol.Name = entry.StringValue("secret")
+		vol.ReadOnly = true
+	default:
+		options, ok := entry.Value("emptyDir")
+		if !ok {
+			return vol, false
+		}
+		vol.Kind = VolumeEmptyDir
+		if parsed, err := ParsePodEntry(options); err == nil {
+			vol.SizeLimit = parsed.StringValue("sizeLimit")
 		}
 	}
+	return vol, true
+}
 
-	return volumes
+// dedupePodVolumes drops entries of the pod directive that mount a volume
+// an earlier entry already mounts.
+func dedupePodVolumes(pod *PodDirective) {
+	seen := make(map[Volume]bool)
+	pod.Remove(func(entry PodEntry) bool {
+		vol, ok := podEntryVolume(entry)
+		if !ok {
+			return false
+		}
+		if seen[vol] {
+			return true
+		}
+		seen[vol] = true
+		return false
+	})
 }
 
+// PrepareFinalConfig writes the k8s scope back in front of the rest of the
+// config. Settings of the k8s.head scope are read by the launcher only and
+// are left out.
 func PrepareFinalConfig(k8sConfig map[string]string, nextflowConfig string) string {
 	finalConfig := "k8s {\n"
 	for key, value := range k8sConfig {
+		if strings.HasPrefix(key, "head.") {
+			continue
+		}
 		finalConfig += fmt.Sprintf("   %s = %s\n", key, value)
 	}
 	finalConfig += "}\n" + nextflowConfig
 	return finalConfig
 }
 
-func AttachVolumesToJob(job *batchv1.Job, volumes []string, secretName string) {
+// LabelWorkerPods returns the k8s.pod setting with entries that set the
+// labels and annotations on the worker pods Nextflow creates. Labels and
+// annotations the setting already has keep their value.
+func LabelWorkerPods(podSetting string, labels, annotations map[string]string) (string, error) {
+	pod, err := ParsePod(podSetting)
+	if err != nil {
+		return "", err
+	}
+	for _, kind := range []string{"label", "annotation"} {
+		values := labels
+		if kind == "annotation" {
+			values = annotations
+		}
+		skipped, err := pod.AddMetadata(kind, values)
+		if err != nil {
+			return "", err
+		}
+		for _, key := range skipped {
+			fmt.Fprintf(os.Stderr, "Note: the k8s.pod setting already sets the %s %s of the worker pods, keeping its value\n", kind, key)
+		}
+	}
+	return pod.String(), nil
+}
+
+// ExtendVolumes adds the volumes given with -v to the volumes of a submitted
+// run. A volume mounted at the same path as one of the run replaces it.
+func ExtendVolumes(volumes, args []string) ([]string, error) {
+	extended := append([]string(nil), volumes...)
+	for _, v := range args {
+		vol, err := ParseVolume(v)
+		if err != nil {
+			return nil, err
+		}
+		replaced := false
+		for i, existing := range extended {
+			if prev, err := ParseVolume(existing); err == nil && prev.MountPath == vol.MountPath {
+				extended[i] = vol.String()
+				replaced = true
+			}
+		}
+		if !replaced {
+			extended = append(extended, vol.String())
+		}
+	}
+	return extended, nil
+}
+
+// BuildVolumes turns -v volume specifications into pod volumes and the
+// matching container mounts.
+func BuildVolumes(volumes []string) ([]corev1.Volume, []corev1.VolumeMount, error) {
+        var podVolumes []corev1.Volume
+        var mounts []corev1.VolumeMount
         mountPathMap := make(map[string]bool)
 	for i, v := range volumes {
-		parts := strings.Split(v, ":")
-		if len(parts) != 2 {
-			err := fmt.Sprintf("Invalid volume format: %s\n", v)
-                        panic(err)
+		vol, err := ParseVolume(v)
+		if err != nil {
+			return nil, nil, err
 		}
 		volName := fmt.Sprintf("vol-%d", i)
-		mount := parts[1]
+		mount := vol.MountPath
                 if _, exists := mountPathMap[mount]; exists {
-                        err := fmt.Sprintf("Duplicate mount path detected: %s\n", mount)
-                        panic(err)
+                        return nil, nil, fmt.Errorf("duplicate mount path %s", mount)
                 }
                 mountPathMap[mount] = true
-		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, corev1.Volume{
-			Name: volName,
-                        VolumeSource: corev1.VolumeSource{
-                           PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-                              ClaimName: parts[0],
-                              ReadOnly:  false,
-                           },
-                        },
+		podVolumes = append(podVolumes, corev1.Volume{
+			Name:         volName,
+			VolumeSource: vol.Source(),
 		})
-		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(
-			job.Spec.Template.Spec.Containers[0].VolumeMounts,
-			corev1.VolumeMount{Name: volName, MountPath: mount},
-		)
+		mounts = append(mounts, corev1.VolumeMount{Name: volName, MountPath: mount, SubPath: vol.SubPath, ReadOnly: vol.ReadOnly})
 	}
+        return podVolumes, mounts, nil
+}
+
+func AttachVolumesToJob(job *batchv1.Job, volumes []string, secretName string) error {
+        podVolumes, mounts, err := BuildVolumes(volumes)
+        if err != nil {
+                return err
+        }
+	job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, podVolumes...)
+	job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts, mounts...)
 	job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, corev1.Volume{
 		Name: "nextflow-config",
 		VolumeSource: corev1.VolumeSource{
@@ -151,6 +287,7 @@ func AttachVolumesToJob(job *batchv1.Job, volumes []string, secretName string) {
 		job.Spec.Template.Spec.Containers[0].VolumeMounts,
 		corev1.VolumeMount{Name: "nextflow-config", MountPath: "/etc/nextflow", ReadOnly: true},
 	)
+	return nil
 }
 
 func Stripped(s string) string {
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxRunNameLength is the longest run name. The name is used for the head
+// job, its container and the job-name label of its pod, so it has to be a
+// DNS label.
+const MaxRunNameLength = 63
+
+// runNamePattern accepts the names that are valid DNS labels and also match
+// the run name pattern of Nextflow, which does not allow trailing or
+// repeated hyphens.
+var runNamePattern = regexp.MustCompile(`^[a-z](-?[a-z0-9])*$`)
+
+var nameTemplatePattern = regexp.MustCompile(`\{[a-z]+\}`)
+
+// ValidateRunName checks that the name can be used for Nextflow and for the
+// Kubernetes objects of the run.
+func ValidateRunName(name string) error {
+	if len(name) > MaxRunNameLength {
+		return fmt.Errorf("run name '%s' is %d characters long, at most %d are allowed", name, len(name), MaxRunNameLength)
+	}
+	if !runNamePattern.MatchString(name) {
+		return fmt.Errorf("run name '%s' must start with a lowercase letter and contain only lowercase letters, digits and single hyphens between them", name)
+	}
+	return nil
+}
+
+// SanitizeRunName turns an arbitrary string into a valid run name.
+func SanitizeRunName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	sanitized := strings.Trim(b.String(), "-")
+	if sanitized == "" || sanitized[0] < 'a' {
+		sanitized = "run-" + sanitized
+	}
+	return truncateRunName(sanitized, MaxRunNameLength)
+}
+
+// RunNameWithSuffix appends the suffix to the name, shortening the name so
+// the result stays within MaxRunNameLength.
+func RunNameWithSuffix(name, suffix string) string {
+	return truncateRunName(name, MaxRunNameLength-len(suffix)) + suffix
+}
+
+func truncateRunName(name string, length int) string {
+	if len(name) > length {
+		name = name[:length]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// ExpandNameTemplate fills in the placeholders of a run name template:
+// {user}, {pipeline}, {date} (YYYYMMDD), {time} (HHMMSS), {rand} (five
+// random letters and digits) and {name} (a random adjective-noun pair).
+// The result is sanitized into a valid run name.
+func ExpandNameTemplate(template, user, pipeline string, now time.Time) (string, error) {
+	var unknown []string
+	expanded := nameTemplatePattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder {
+		case "{user}":
+			return user
+		case "{pipeline}":
+			return pipelineName(pipeline)
+		case "{date}":
+			return now.Format("20060102")
+		case "{time}":
+			return now.Format("150405")
+		case "{rand}":
+			return randomSuffix(5)
+		case "{name}":
+			return GenerateRandomName()
+		}
+		unknown = append(unknown, placeholder)
+		return placeholder
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholder %s in name template '%s', expected {user}, {pipeline}, {date}, {time}, {rand} or {name}", strings.Join(unknown, ", "), template)
+	}
+	return SanitizeRunName(expanded), nil
+}
+
+// pipelineName returns the short name of a pipeline repository or path,
+// such as rnaseq for nf-core/rnaseq or https://github.com/nf-core/rnaseq.git.
+func pipelineName(pipeline string) string {
+	name := path.Base(strings.TrimRight(pipeline, "/"))
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".git"), ".nf")
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+func randomSuffix(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}
@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PodDirective is the parsed value of the k8s.pod setting, a Groovy list of
+// maps such as [[volumeClaim:'data', mountPath:'/data'], [env:'A', value:'b']].
+// Entries keep their original text, so entries that are not touched are
+// printed back unchanged.
+type PodDirective struct {
+	original string
+	changed  bool
+	Entries  []PodEntry
+}
+
+// PodEntry is one map of the pod directive.
+type PodEntry struct {
+	Raw    string
+	Fields []PodField
+}
+
+// PodField is a key of a pod entry with the source text of its value.
+type PodField struct {
+	Key   string
+	Value string
+}
+
+// ParsePod parses the value of the k8s.pod setting. A single map is accepted
+// too, as Nextflow does.
+func ParsePod(value string) (*PodDirective, error) {
+	pod := &PodDirective{original: value}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return pod, nil
+	}
+	items, isMap, err := splitLiteral(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid k8s.pod setting: %v", err)
+	}
+	if isMap {
+		entry, err := ParsePodEntry(value)
+		if err != nil {
+			return nil, err
+		}
+		pod.Entries = []PodEntry{entry}
+		return pod, nil
+	}
+	for _, item := range items {
+		entry, err := ParsePodEntry(item)
+		if err != nil {
+			return nil, err
+		}
+		pod.Entries = append(pod.Entries, entry)
+	}
+	return pod, nil
+}
+
+// ParsePodEntry parses a single map of the pod directive.
+func ParsePodEntry(value string) (PodEntry, error) {
+	value = strings.TrimSpace(value)
+	entry := PodEntry{Raw: value}
+	items, isMap, err := splitLiteral(value)
+	if err != nil {
+		return entry, fmt.Errorf("invalid k8s.pod entry %s: %v", value, err)
+	}
+	if !isMap && len(items) > 0 {
+		return entry, fmt.Errorf("invalid k8s.pod entry %s: not a map", value)
+	}
+	for _, item := range items {
+		key, val, _ := cutTopLevel(item, ':')
+		entry.Fields = append(entry.Fields, PodField{
+			Key:   Stripped(strings.TrimSpace(key)),
+			Value: strings.TrimSpace(val),
+		})
+	}
+	return entry, nil
+}
+
+// Value returns the source text of the value of key.
+func (e PodEntry) Value(key string) (string, bool) {
+	for _, field := range e.Fields {
+		if field.Key == key {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+// StringValue returns the value of key when it is a string literal.
+func (e PodEntry) StringValue(key string) string {
+	value, _ := e.Value(key)
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		return strings.ReplaceAll(value[1:len(value)-1], `\`+value[:1], value[:1])
+	}
+	return ""
+}
+
+// Add appends an entry given as the content of a map, e.g.
+// volumeClaim:'data', mountPath:'/data'.
+func (p *PodDirective) Add(content string) error {
+	entry, err := ParsePodEntry("[" + content + "]")
+	if err != nil {
+		return err
+	}
+	p.Entries = append(p.Entries, entry)
+	p.changed = true
+	return nil
+}
+
+// AddMetadata adds an entry setting the label or annotation (kind) for each
+// key the directive does not set yet. It returns the keys that were already
+// set, which keep their value.
+func (p *PodDirective) AddMetadata(kind string, values map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var skipped []string
+	for _, key := range keys {
+		exists := false
+		for _, entry := range p.Entries {
+			if entry.StringValue(kind) == key {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			skipped = append(skipped, key)
+			continue
+		}
+		if err := p.Add(PodMetadataEntry(kind, key, values[key])); err != nil {
+			return nil, err
+		}
+	}
+	return skipped, nil
+}
+
+// PodMetadataEntry returns the content of an entry setting the label or
+// annotation (kind) key of the worker pods.
+func PodMetadataEntry(kind, key, value string) string {
+	return fmt.Sprintf("%s:%s, value:%s", kind, groovyString(key), groovyString(value))
+}
+
+// groovyString quotes s as a single-quoted Groovy string.
+func groovyString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// Remove drops the entries for which drop returns true.
+func (p *PodDirective) Remove(drop func(PodEntry) bool) {
+	kept := p.Entries[:0]
+	for _, entry := range p.Entries {
+		if drop(entry) {
+			p.changed = true
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	p.Entries = kept
+}
+
+// String prints the directive back. The original text is returned when no
+// entry was added or removed.
+func (p *PodDirective) String() string {
+	if !p.changed {
+		return p.original
+	}
+	raws := make([]string, len(p.Entries))
+	for i, entry := range p.Entries {
+		raws[i] = entry.Raw
+	}
+	return "[" + strings.Join(raws, ", ") + "]"
+}
+
+// splitLiteral splits the top-level elements of a Groovy list or map literal
+// and reports whether it is a map. Strings, closures and nested literals are
+// kept whole.
+func splitLiteral(value string) ([]string, bool, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, false, fmt.Errorf("expected a [...] literal")
+	}
+	if end, err := closingBracket(value); err != nil {
+		return nil, false, err
+	} else if end != len(value)-1 {
+		return nil, false, fmt.Errorf("unexpected text after position %d", end+1)
+	}
+	inner := strings.TrimSpace(value[1 : len(value)-1])
+	if inner == ":" {
+		return nil, true, nil
+	}
+	if inner == "" {
+		return nil, false, nil
+	}
+
+	var items []string
+	isMap := false
+	for inner != "" {
+		item, rest, found := cutTopLevel(inner, ',')
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, false, fmt.Errorf("empty element")
+		}
+		if _, _, colon := cutTopLevel(item, ':'); colon {
+			isMap = true
+		}
+		items = append(items, item)
+		if !found {
+			break
+		}
+		inner = strings.TrimSpace(rest)
+	}
+	return items, isMap, nil
+}
+
+// cutTopLevel cuts s around the first sep that is not inside a string or a
+// bracket, brace or parenthesis.
+func cutTopLevel(s string, sep byte) (string, string, bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\'', '"':
+			i = stringEnd(s, i)
+		case '[', '{', '(':
+			depth++
+		case ']', '}', ')':
+			depth--
+		default:
+			if c == sep && depth == 0 {
+				return s[:i], s[i+1:], true
+			}
+		}
+	}
+	return s, "", false
+}
+
+// closingBracket returns the position of the bracket closing the one at the
+// start of s.
+func closingBracket(s string) (int, error) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"':
+			end := stringEnd(s, i)
+			if end >= len(s) {
+				return 0, fmt.Errorf("unterminated string")
+			}
+			i = end
+		case '[', '{', '(':
+			depth++
+		case ']', '}', ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unbalanced brackets")
+}
+
+// stringEnd returns the position of the quote closing the string literal
+// starting at i, honouring backslash escapes and triple quotes.
+func stringEnd(s string, i int) int {
+	quote := s[i : i+1]
+	if strings.HasPrefix(s[i:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	for j := i + len(quote); j < len(s); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if strings.HasPrefix(s[j:], quote) {
+			return j + len(quote) - 1
+		}
+	}
+	return len(s)
+}
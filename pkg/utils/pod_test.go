@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePod(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  [][]PodField
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "single map",
+			value: "[volumeClaim:'data', mountPath:'/data']",
+			want:  [][]PodField{{{"volumeClaim", "'data'"}, {"mountPath", "'/data'"}}},
+		},
+		{
+			name:  "list of maps",
+			value: "[[env:'A', value:'b'], [label:'team', value:'x']]",
+			want: [][]PodField{
+				{{"env", "'A'"}, {"value", "'b'"}},
+				{{"label", "'team'"}, {"value", "'x'"}},
+			},
+		},
+		{
+			name:  "nested map",
+			value: "[[emptyDir:[medium:'Memory', sizeLimit:'1Gi'], mountPath:'/scratch']]",
+			want:  [][]PodField{{{"emptyDir", "[medium:'Memory', sizeLimit:'1Gi']"}, {"mountPath", "'/scratch'"}}},
+		},
+		{
+			name:  "nested list",
+			value: "[[toleration:[key:'gpu', operator:'Exists'], effect:['NoSchedule', 'NoExecute']]]",
+			want:  [][]PodField{{{"toleration", "[key:'gpu', operator:'Exists']"}, {"effect", "['NoSchedule', 'NoExecute']"}}},
+		},
+		{
+			name:  "quoted comma and colon",
+			value: `[[annotation:'note', value:'a, b: c'], [env:"X", value:"1,2"]]`,
+			want: [][]PodField{
+				{{"annotation", "'note'"}, {"value", "'a, b: c'"}},
+				{{"env", `"X"`}, {"value", `"1,2"`}},
+			},
+		},
+		{
+			name:  "quoted brackets",
+			value: `[[annotation:'expr', value:'[x]]'], [env:'B', value:"{[("]]`,
+			want: [][]PodField{
+				{{"annotation", "'expr'"}, {"value", "'[x]]'"}},
+				{{"env", "'B'"}, {"value", `"{[("`}},
+			},
+		},
+		{
+			name:  "escaped quote",
+			value: `[[label:'it\'s', value:'a,b']]`,
+			want:  [][]PodField{{{"label", `'it\'s'`}, {"value", "'a,b'"}}},
+		},
+		{
+			name:  "quoted key",
+			value: `[['label':'team', value:'x']]`,
+			want:  [][]PodField{{{"label", "'team'"}, {"value", "'x'"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod, err := ParsePod(tt.value)
+			if err != nil {
+				t.Fatalf("ParsePod(%q): %v", tt.value, err)
+			}
+			var got [][]PodField
+			for _, entry := range pod.Entries {
+				got = append(got, entry.Fields)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePod(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+			if pod.String() != tt.value {
+				t.Errorf("String() = %q, want the original %q", pod.String(), tt.value)
+			}
+		})
+	}
+}
+
+func TestParsePodErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"not a literal", "volumeClaim:'data'"},
+		{"unbalanced", "[[env:'A', value:'b']"},
+		{"unterminated string", "[[env:'A, value:'b']]"},
+		{"trailing text", "[env:'A'] x"},
+		{"empty element", "[[env:'A'],, [env:'B']]"},
+		{"entry not a map", "[['a', 'b']]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePod(tt.value); err == nil {
+				t.Errorf("ParsePod(%q) succeeded, want an error", tt.value)
+			}
+		})
+	}
+}
+
+func TestStringValue(t *testing.T) {
+	tests := []struct {
+		entry string
+		key   string
+		want  string
+	}{
+		{"[label:'team', value:'x']", "label", "team"},
+		{`[label:"team", value:"x"]`, "value", "x"},
+		{`[label:'it\'s', value:'x']`, "label", "it's"},
+		{"[emptyDir:[:], mountPath:'/tmp']", "emptyDir", ""},
+		{"[readOnly:true, mountPath:'/tmp']", "readOnly", ""},
+		{"[label:'team']", "value", ""},
+	}
+	for _, tt := range tests {
+		entry, err := ParsePodEntry(tt.entry)
+		if err != nil {
+			t.Fatalf("ParsePodEntry(%q): %v", tt.entry, err)
+		}
+		if got := entry.StringValue(tt.key); got != tt.want {
+			t.Errorf("StringValue(%q) of %s = %q, want %q", tt.key, tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestLabelWorkerPods(t *testing.T) {
+	tests := []struct {
+		name        string
+		pod         string
+		labels      map[string]string
+		annotations map[string]string
+		want        string
+	}{
+		{
+			name:   "no setting",
+			pod:    "",
+			labels: map[string]string{"runName": "happy-turing"},
+			want:   "[[label:'runName', value:'happy-turing']]",
+		},
+		{
+			name:        "sorted keys",
+			pod:         "[volumeClaim:'data', mountPath:'/data']",
+			labels:      map[string]string{"team": "x", "runName": "r"},
+			annotations: map[string]string{"note": "it's, [odd]"},
+			want:        `[[volumeClaim:'data', mountPath:'/data'], [label:'runName', value:'r'], [label:'team', value:'x'], [annotation:'note', value:'it\'s, [odd]']]`,
+		},
+		{
+			name:   "existing label wins",
+			pod:    "[[label:'team', value:'mine']]",
+			labels: map[string]string{"team": "x", "runName": "r"},
+			want:   "[[label:'team', value:'mine'], [label:'runName', value:'r']]",
+		},
+		{
+			name:        "label and annotation with the same key",
+			pod:         "[[annotation:'team', value:'mine']]",
+			labels:      map[string]string{"team": "x"},
+			annotations: map[string]string{"team": "y"},
+			want:        "[[annotation:'team', value:'mine'], [label:'team', value:'x']]",
+		},
+		{
+			name:   "nothing to add",
+			pod:    "[ [label:'team', value:'mine'] ]",
+			labels: map[string]string{"team": "x"},
+			want:   "[ [label:'team', value:'mine'] ]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LabelWorkerPods(tt.pod, tt.labels, tt.annotations)
+			if err != nil {
+				t.Fatalf("LabelWorkerPods: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("LabelWorkerPods(%q) = %s, want %s", tt.pod, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeVolumesPodSetting(t *testing.T) {
+	tests := []struct {
+		name    string
+		pod     string
+		volumes []string
+		want    string
+	}{
+		{
+			name: "duplicate entries are merged",
+			pod:  "[[volumeClaim:'data', mountPath:'/data'], [env:'A', value:'b'], [volumeClaim:'data', mountPath:'/data']]",
+			want: "[[volumeClaim:'data', mountPath:'/data'], [env:'A', value:'b']]",
+		},
+		{
+			name:    "-v replaces the entry at the same mount path",
+			pod:     "[[volumeClaim:'old', mountPath:'/ref'], [env:'A', value:'b']]",
+			volumes: []string{"data:/work", "ref:/ref:ro"},
+			want:    "[[env:'A', value:'b'], [volumeClaim:'ref', mountPath:'/ref', readOnly:true]]",
+		},
+		{
+			name:    "-v matching an entry keeps it",
+			pod:     "[[volumeClaim:'ref', mountPath:'/ref']]",
+			volumes: []string{"data:/work", "ref:/ref"},
+			want:    "[[volumeClaim:'ref', mountPath:'/ref']]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sConfig := map[string]string{"pod": tt.pod}
+			if _, err := NormalizeVolumes(tt.volumes, k8sConfig); err != nil {
+				t.Fatalf("NormalizeVolumes: %v", err)
+			}
+			if k8sConfig["pod"] != tt.want {
+				t.Errorf("pod = %s, want %s", k8sConfig["pod"], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PartialSuffix marks a file that is still being written by ExtractTar.
+const PartialSuffix = ".part"
+
+// ExtractTar unpacks a tar stream into dest and returns the extracted files.
+// Entries that would end up outside of dest are rejected. Each file is written
+// next to its target with PartialSuffix and renamed once it is complete.
+func ExtractTar(r io.Reader, dest string) ([]string, error) {
+	var files []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+
+		target := filepath.Join(dest, filepath.Clean("/"+hdr.Name))
+		if target != dest && !strings.HasPrefix(target, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return files, fmt.Errorf("refusing to extract %s outside of %s", hdr.Name, dest)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return files, err
+			}
+			partial := target + PartialSuffix
+			f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0777|0600)
+			if err != nil {
+				return files, err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return files, err
+			}
+			if err := f.Close(); err != nil {
+				return files, err
+			}
+			if err := os.Rename(partial, target); err != nil {
+				return files, err
+			}
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+			files = append(files, target)
+		}
+	}
+}
+
+// ShellQuote quotes s for use as a single word in a POSIX shell.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,28 @@
+package utils
+
+import "runtime/debug"
+
+// Version is the version of the launcher. Release builds set it with
+// -ldflags "-X nextflow-go/pkg/utils.Version=v1.2.3"; otherwise the module
+// version or VCS revision recorded by the Go toolchain is used.
+var Version = ""
+
+// LauncherVersion returns the version of the launcher.
+func LauncherVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return "dev-" + setting.Value[:12]
+		}
+	}
+	return "dev"
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Volume kinds of the -v grammar.
+const (
+	VolumePVC       = "pvc"
+	VolumeConfigMap = "configmap"
+	VolumeSecret    = "secret"
+	VolumeEmptyDir  = "emptydir"
+	VolumeNFS       = "nfs"
+	// VolumeNew is a PVC that is created when it does not exist yet.
+	VolumeNew = "new"
+)
+
+// Volume is a parsed -v specification:
+//
+//	pvc[/subpath]:dir[:ro]
+//	configmap:name:dir
+//	secret:name:dir
+//	emptydir:dir[:sizeLimit]
+//	nfs:server:/export:dir[:ro]
+//	new:name:size[:storageClass]:dir
+//
+// A new volume is a PVC with Create set.
+type Volume struct {
+	Kind      string
+	Name      string
+	SubPath   string
+	MountPath string
+	ReadOnly  bool
+	SizeLimit string
+	Server    string
+	Export    string
+	// Create, Size and StorageClass describe a PVC to create when it is
+	// missing.
+	Create       bool
+	Size         string
+	StorageClass string
+}
+
+// ParseVolume parses a -v specification.
+func ParseVolume(spec string) (Volume, error) {
+	parts := strings.Split(spec, ":")
+	invalid := fmt.Errorf("invalid volume '%s'", spec)
+	readOnly := func(parts []string) ([]string, bool) {
+		if len(parts) > 0 && parts[len(parts)-1] == "ro" {
+			return parts[:len(parts)-1], true
+		}
+		return parts, false
+	}
+
+	var v Volume
+	switch parts[0] {
+	case VolumeConfigMap, VolumeSecret:
+		if len(parts) != 3 || parts[1] == "" {
+			return v, invalid
+		}
+		v = Volume{Kind: parts[0], Name: parts[1], MountPath: parts[2], ReadOnly: true}
+	case VolumeEmptyDir:
+		if len(parts) != 2 && len(parts) != 3 {
+			return v, invalid
+		}
+		v = Volume{Kind: VolumeEmptyDir, MountPath: parts[1]}
+		if len(parts) == 3 {
+			if _, err := resource.ParseQuantity(parts[2]); err != nil {
+				return v, fmt.Errorf("invalid size limit in volume '%s': %v", spec, err)
+			}
+			v.SizeLimit = parts[2]
+		}
+	case VolumeNew:
+		if len(parts) != 4 && len(parts) != 5 || parts[1] == "" {
+			return v, invalid
+		}
+		if _, err := resource.ParseQuantity(parts[2]); err != nil {
+			return v, fmt.Errorf("invalid size in volume '%s': %v", spec, err)
+		}
+		v = Volume{Kind: VolumePVC, Name: parts[1], Size: parts[2], MountPath: parts[len(parts)-1], Create: true}
+		if len(parts) == 5 {
+			v.StorageClass = parts[3]
+		}
+	case VolumeNFS:
+		rest, ro := readOnly(parts[1:])
+		if len(rest) != 3 || rest[0] == "" || !path.IsAbs(rest[1]) {
+			return v, invalid
+		}
+		v = Volume{Kind: VolumeNFS, Server: rest[0], Export: rest[1], MountPath: rest[2], ReadOnly: ro}
+	default:
+		rest, ro := readOnly(parts)
+		if len(rest) != 2 || rest[0] == "" {
+			return v, invalid
+		}
+		claim, subPath, _ := strings.Cut(rest[0], "/")
+		v = Volume{Kind: VolumePVC, Name: claim, SubPath: strings.Trim(subPath, "/"), MountPath: rest[1], ReadOnly: ro}
+	}
+	if !path.IsAbs(v.MountPath) {
+		return v, fmt.Errorf("mount path of volume '%s' must be absolute", spec)
+	}
+	return v, nil
+}
+
+// String returns the -v specification of the volume. A new volume is
+// returned as a plain PVC.
+func (v Volume) String() string {
+	var spec string
+	switch v.Kind {
+	case VolumeConfigMap, VolumeSecret:
+		return fmt.Sprintf("%s:%s:%s", v.Kind, v.Name, v.MountPath)
+	case VolumeEmptyDir:
+		if v.SizeLimit != "" {
+			return fmt.Sprintf("emptydir:%s:%s", v.MountPath, v.SizeLimit)
+		}
+		return "emptydir:" + v.MountPath
+	case VolumeNFS:
+		spec = fmt.Sprintf("nfs:%s:%s:%s", v.Server, v.Export, v.MountPath)
+	default:
+		spec = v.Name
+		if v.SubPath != "" {
+			spec += "/" + v.SubPath
+		}
+		spec += ":" + v.MountPath
+	}
+	if v.ReadOnly {
+		spec += ":ro"
+	}
+	return spec
+}
+
+// Persistent reports whether the volume outlives the pod and is shared with
+// the other pods of the run.
+func (v Volume) Persistent() bool {
+	return v.Kind == VolumePVC || v.Kind == VolumeNFS
+}
+
+// PodEntry returns the entry of the k8s.pod directive that gives worker pods
+// the same mount, or false when Nextflow cannot express the volume.
+func (v Volume) PodEntry() (string, bool) {
+	var entry string
+	switch v.Kind {
+	case VolumeConfigMap:
+		entry = fmt.Sprintf("config:'%s', mountPath:'%s'", v.Name, v.MountPath)
+	case VolumeSecret:
+		entry = fmt.Sprintf("secret:'%s', mountPath:'%s'", v.Name, v.MountPath)
+	case VolumeEmptyDir:
+		if v.SizeLimit != "" {
+			entry = fmt.Sprintf("emptyDir:[sizeLimit:'%s'], mountPath:'%s'", v.SizeLimit, v.MountPath)
+		} else {
+			entry = fmt.Sprintf("emptyDir:[:], mountPath:'%s'", v.MountPath)
+		}
+	case VolumePVC:
+		entry = fmt.Sprintf("volumeClaim:'%s', mountPath:'%s'", v.Name, v.MountPath)
+		if v.SubPath != "" {
+			entry += fmt.Sprintf(", subPath:'%s'", v.SubPath)
+		}
+		if v.ReadOnly {
+			entry += ", readOnly:true"
+		}
+	default:
+		return "", false
+	}
+	return entry, true
+}
+
+// Source returns the pod volume source of the volume.
+func (v Volume) Source() corev1.VolumeSource {
+	switch v.Kind {
+	case VolumeConfigMap:
+		return corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: v.Name}}}
+	case VolumeSecret:
+		return corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: v.Name}}
+	case VolumeEmptyDir:
+		source := &corev1.EmptyDirVolumeSource{}
+		if v.SizeLimit != "" {
+			limit := resource.MustParse(v.SizeLimit)
+			source.SizeLimit = &limit
+		}
+		return corev1.VolumeSource{EmptyDir: source}
+	case VolumeNFS:
+		return corev1.VolumeSource{NFS: &corev1.NFSVolumeSource{Server: v.Server, Path: v.Export, ReadOnly: v.ReadOnly}}
+	default:
+		return corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: v.Name, ReadOnly: v.ReadOnly}}
+	}
+}